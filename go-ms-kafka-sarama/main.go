@@ -52,7 +52,20 @@ func main() {
 	}
 	collection := db.Collection("example")
 	repo := repository.NewRepository[handler.Example](collection)
-	ms := microservice.NewApplication(servers, groupID, zapLogger)
+	ms := microservice.NewApplicationWithConfig(microservice.KafkaConfig{
+		Brokers:  servers,
+		GroupID:  groupID,
+		ClientID: "verify-service",
+		SASL: microservice.SASLConfig{
+			Mechanism: os.Getenv("KAFKA_SASL_MECHANISM"),
+			Username:  os.Getenv("KAFKA_SASL_USERNAME"),
+			Password:  os.Getenv("KAFKA_SASL_PASSWORD"),
+		},
+		TLS: microservice.TLSConfig{
+			Enabled: os.Getenv("KAFKA_TLS") == "true",
+			CAFile:  os.Getenv("KAFKA_TLS_CA_FILE"),
+		},
+	}, zapLogger)
 	ms.Log("Starting microservice")
 
 	ms.Consume(ServiceRegisterTopic, handler.NewHandler(repo).HandlerRegister)
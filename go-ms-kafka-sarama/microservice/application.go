@@ -1,6 +1,7 @@
 package microservice
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"strings"
@@ -39,6 +40,7 @@ type application struct {
 	exitChannel chan bool
 	brokers     []string
 	groupID     string
+	kafka       KafkaConfig
 	client      sarama.ConsumerGroup
 	Logger      *zap.Logger
 	producer    *sarama.SyncProducer
@@ -88,6 +90,8 @@ type IContext interface {
 	Response(responseCode int, responseData interface{})
 	ReadInput() string
 	CommonLog(scenario string) (logger.DetailLog, logger.SummaryLog)
+	// Context carries the consumer trace span, SendMessage continues it in the produced message
+	Context() context.Context
 	// SendMail(message Message) error
 	SendMessage(topic string, message interface{}, opts ...OptionProducerMessage) error
 }
@@ -97,9 +101,15 @@ type ServiceHandleFunc func(ctx IContext) error
 
 // NewMicroservice is the constructor function of Microservice
 func NewApplication(brokers, groupID string, log ...*zap.Logger) IApplication {
+	return NewApplicationWithConfig(KafkaConfig{Brokers: brokers, GroupID: groupID}, log...)
+}
+
+// NewApplicationWithConfig is NewApplication with the client id and security of the Kafka clients
+func NewApplicationWithConfig(cfg KafkaConfig, log ...*zap.Logger) IApplication {
 	app := &application{
-		brokers: strings.Split(brokers, ","),
-		groupID: groupID,
+		brokers: strings.Split(cfg.Brokers, ","),
+		groupID: cfg.GroupID,
+		kafka:   cfg,
 		Logger:  logger.NewLogger(),
 	}
 
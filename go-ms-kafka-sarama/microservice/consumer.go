@@ -18,6 +18,7 @@ import (
 
 // ConsumerContext implements IContext
 type ConsumerContext struct {
+	ctx      context.Context
 	ms       *application
 	message  string
 	msg      *sarama.ConsumerMessage
@@ -30,12 +31,18 @@ type ConsumerContext struct {
 // NewConsumerContext is the constructor function for ConsumerContext
 func NewConsumerContext(ms *application, msg *sarama.ConsumerMessage) *ConsumerContext {
 	return &ConsumerContext{
+		ctx:     context.Background(),
 		ms:      ms,
 		msg:     msg,
 		message: string(msg.Value),
 	}
 }
 
+// Context returns the message context, it carries the consumer trace span
+func (ctx *ConsumerContext) Context() context.Context {
+	return ctx.ctx
+}
+
 // Log logs a message
 func (ctx *ConsumerContext) Log(message string, fields ...map[string]any) {
 	ctx.ms.Log(message, fields...)
@@ -144,12 +151,15 @@ func (ctx *ConsumerContext) SendMessage(topic string, message interface{}, opts
 
 	invoke := logger.GenerateXTid(topic)
 
+	span := startProducerSpan(ctx.ctx, msg)
+
 	if ctx.l != nil {
 		ctx.l.AddOutputRequest("kafka_producer", topic, invoke, msg, msg)
 		ctx.l.End()
 	}
 
 	partition, offset, err := producer.SendMessage(msg)
+	endSpan(span, err)
 	if err != nil {
 		ctx.l.AddOutputRequest("kafka_producer", topic, invoke, err.Error(), map[string]any{"error": err.Error()})
 		ctx.s.AddError("kafka_producer", topic, invoke, err.Error())
@@ -335,8 +345,12 @@ func (handler *ConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSe
 		handler.ms.Logger = l
 
 		handler.ms.Log(fmt.Sprintf("Consumer: %s", msg.Topic))
+		spanCtx, span := startConsumerSpan(msg)
 		ctx := NewConsumerContext(handler.ms, msg)
-		if err := handler.h(ctx); err != nil {
+		ctx.ctx = spanCtx
+		err := handler.h(ctx)
+		endSpan(span, err)
+		if err != nil {
 			handler.ms.Log(fmt.Sprintf("Consumer error: %v", err))
 		}
 		session.MarkMessage(msg, "")
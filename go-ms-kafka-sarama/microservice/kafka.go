@@ -12,10 +12,13 @@ import (
 )
 
 func (ms *application) NewProducer() sarama.SyncProducer {
-	config := sarama.NewConfig()
+	config, err := ms.newSaramaConfig()
+	if err != nil {
+		ms.Log(fmt.Sprintf("Error creating producer: %v", err))
+		return nil
+	}
 	config.Producer.Return.Successes = true
 	config.Producer.Return.Errors = true
-	config.Version = sarama.V2_5_0_0 // Set to Kafka version used
 	producer, err := sarama.NewSyncProducer(ms.brokers, config)
 	if err != nil {
 		ms.Log(fmt.Sprintf("Error creating producer: %v", err))
@@ -37,10 +40,13 @@ func (ms *application) getProducer() sarama.SyncProducer {
 // Consume registers a consumer for the service
 func (ms *application) Consume(topic string, h ServiceHandleFunc) error {
 	if ms.client == nil {
-		config := sarama.NewConfig()
+		config, err := ms.newSaramaConfig()
+		if err != nil {
+			ms.Log(fmt.Sprintf("Error creating consumer group: %v", err))
+			return err
+		}
 		config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
 		config.Consumer.Offsets.Initial = sarama.OffsetOldest
-		config.Version = sarama.V2_5_0_0 // Set to Kafka version used
 		client, err := sarama.NewConsumerGroup(ms.brokers, ms.groupID, config)
 		if err != nil {
 			ms.Log(fmt.Sprintf("Error creating consumer group: %v", err))
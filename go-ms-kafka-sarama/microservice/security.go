@@ -0,0 +1,150 @@
+package microservice
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SASL mechanisms accepted by SASLConfig.Mechanism.
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaConfig configures the brokers, the consumer group and the security of the sarama clients.
+type KafkaConfig struct {
+	Brokers string
+	GroupID string
+	// ClientID identifies the service in the broker logs and quotas.
+	ClientID string
+	SASL     SASLConfig
+	TLS      TLSConfig
+}
+
+// SASLConfig authenticates the clients with a username and password.
+type SASLConfig struct {
+	// Mechanism is PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, SASL is disabled when empty.
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// TLSConfig encrypts the connection to the brokers.
+type TLSConfig struct {
+	Enabled bool
+	// CAFile verifies the broker certificates, the system roots are used when empty.
+	CAFile string
+	// CertFile and KeyFile authenticate the client with a certificate.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify accepts any broker certificate, for development only.
+	InsecureSkipVerify bool
+}
+
+// newSaramaConfig returns the config shared by the producer and the consumer group.
+func (ms *application) newSaramaConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0 // Set to Kafka version used
+	if ms.kafka.ClientID != "" {
+		config.ClientID = ms.kafka.ClientID
+	}
+
+	if sasl := ms.kafka.SASL; sasl.Mechanism != "" {
+		if sasl.Username == "" {
+			return nil, errors.New("SASL requires a username")
+		}
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.User = sasl.Username
+		config.Net.SASL.Password = sasl.Password
+
+		switch strings.ToUpper(sasl.Mechanism) {
+		case SASLPlain:
+			config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case SASLScramSHA256:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: scram.SHA256}
+			}
+		case SASLScramSHA512:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: scram.SHA512}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported SASL mechanism %s", sasl.Mechanism)
+		}
+	}
+
+	if ms.kafka.TLS.Enabled {
+		tlsConfig, err := ms.kafka.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+	return config, nil
+}
+
+func (t TLSConfig) config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		ca, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, errors.New("TLS client authentication requires both a certificate and a key")
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// scramClient implements sarama.SCRAMClient on top of xdg-go/scram.
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
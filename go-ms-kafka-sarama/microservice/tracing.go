@@ -0,0 +1,118 @@
+package microservice
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/sing3demons/saram-kafka/microservice"
+
+// propagator reads and writes the W3C traceparent header of the Kafka messages.
+var propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// consumerHeaderCarrier adapts the headers of a consumed message to the propagator.
+type consumerHeaderCarrier []*sarama.RecordHeader
+
+func (c consumerHeaderCarrier) Get(key string) string {
+	for _, h := range c {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c consumerHeaderCarrier) Set(key, value string) {}
+
+func (c consumerHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for _, h := range c {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return keys
+}
+
+// producerHeaderCarrier adapts the headers of a message being produced to the propagator.
+type producerHeaderCarrier struct {
+	msg *sarama.ProducerMessage
+}
+
+func (c producerHeaderCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c producerHeaderCarrier) Set(key, value string) {
+	for i := range c.msg.Headers {
+		if string(c.msg.Headers[i].Key) == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c producerHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, string(h.Key))
+	}
+	return keys
+}
+
+// startConsumerSpan continues the trace of msg in a consumer span.
+func startConsumerSpan(msg *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	ctx := propagator.Extract(context.Background(), consumerHeaderCarrier(msg.Headers))
+	return tracer().Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", msg.Topic),
+			attribute.Int("messaging.destination.partition.id", int(msg.Partition)),
+			attribute.Int64("messaging.kafka.message.offset", msg.Offset),
+		),
+	)
+}
+
+// startProducerSpan opens a producer span and injects its traceparent into msg headers.
+func startProducerSpan(ctx context.Context, msg *sarama.ProducerMessage) trace.Span {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer().Start(ctx, msg.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", msg.Topic),
+		),
+	)
+	propagator.Inject(ctx, producerHeaderCarrier{msg})
+	return span
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
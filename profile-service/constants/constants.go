@@ -9,4 +9,10 @@ const (
 	ContentType                = "Content-Type"
 	ContentTypeJSON            = "application/json"
 	ContentJson                = "application/json"
-)
\ No newline at end of file
+)
+
+// SessionHeader carries the session on HTTP requests and mails, KafkaSessionHeader on Kafka messages.
+const (
+	SessionHeader      = "X-Session"
+	KafkaSessionHeader = "x-session"
+)
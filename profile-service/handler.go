@@ -0,0 +1,342 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/sing3demons/profile-service/constants"
+	"github.com/sing3demons/profile-service/ms"
+	"github.com/sing3demons/profile-service/store"
+	"github.com/sing3demons/profile-service/utils"
+)
+
+type Profile struct {
+	ID           string `json:"id"`
+	Href         string `json:"href,omitempty"`
+	Email        string `json:"email,omitempty"`
+	UserName     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	FirstName    string `json:"first_name,omitempty"`
+	LastName     string `json:"last_name,omitempty"`
+	DateOfBirth  string `json:"date_of_birth,omitempty"`
+	PhoneNumber  string `json:"phone_number,omitempty"`
+	Gender       string `json:"gender,omitempty"`
+	CreatedAt    string `json:"created_at,omitempty"`
+	UpdatedAt    string `json:"updated_at,omitempty"`
+	CreatedBy    string `json:"created_by,omitempty"`
+	UpdatedBy    string `json:"updated_by,omitempty"`
+	DisplayName  string `json:"display_name,omitempty"`
+	ProfileImage string `json:"profile_image,omitempty"`
+	IsActive     bool   `json:"is_active"`
+}
+
+type CreateProfile struct {
+	Email        string `json:"email" validate:"required,email"`
+	Username     string `json:"username" validate:"required"`
+	Password     string `json:"password" validate:"required"`
+	FirstName    string `json:"first_name,omitempty"`
+	LastName     string `json:"last_name,omitempty"`
+	DateOfBirth  string `json:"date_of_birth,omitempty"`
+	PhoneNumber  string `json:"phone_number,omitempty"`
+	Gender       string `json:"gender,omitempty"`
+	DisplayName  string `json:"display_name,omitempty"`
+	ProfileImage string `json:"profile_image,omitempty"`
+	CreatedBy    string `json:"created_by,omitempty"`
+}
+
+type ListUsersQuery struct {
+	Email       string `query:"email"`
+	Username    string `query:"username"`
+	Limit       *int   `query:"limit" validate:"min=1"`
+	Offset      *int   `query:"offset" validate:"min=0"`
+	CreatedFrom string `query:"created_from"`
+	CreatedTo   string `query:"created_to"`
+}
+
+type ProfileList struct {
+	Total  int       `json:"total"`
+	Limit  int       `json:"limit"`
+	Offset int       `json:"offset"`
+	Data   []Profile `json:"data"`
+}
+
+type Handler struct {
+	*store.Storer
+	db *sql.DB
+}
+
+func toProfile(user *store.User) Profile {
+	return Profile{
+		ID:           user.ID,
+		Href:         "http://localhost:8080/users/" + user.ID,
+		Email:        user.Email,
+		UserName:     user.Username,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		DateOfBirth:  user.DateOfBirth,
+		PhoneNumber:  user.PhoneNumber,
+		Gender:       user.Gender,
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+		CreatedBy:    user.CreatedBy,
+		UpdatedBy:    user.UpdatedBy,
+		DisplayName:  user.DisplayName,
+		ProfileImage: user.ProfileImage,
+		IsActive:     user.IsActive,
+	}
+}
+
+// mapStoreErrors makes handlers answer the store sentinel errors with their HTTP status.
+func mapStoreErrors(app ms.IMicroservice) {
+	app.MapError(store.ErrNotFound, ms.NewError(http.StatusNotFound, "not_found", "resource not found"))
+	app.MapError(store.ErrConflict, ms.NewError(http.StatusConflict, "conflict", "resource already exists"))
+	app.MapError(store.ErrDuplicateEmail, ms.NewError(http.StatusConflict, "duplicate_email", "a user with that email already exists"))
+	app.MapError(store.ErrDuplicateUsername, ms.NewError(http.StatusConflict, "duplicate_username", "a user with that username already exists"))
+}
+
+func (s Handler) GetUserByPublicId(c ms.IContext) error {
+	initInvoke := ms.GenerateXTid("profile")
+	cmd := "get_user_by_id"
+
+	_, summaryLog := c.CommonLog(initInvoke, cmd, "anonymous")
+	summaryLog.AddSuccessBlock(constants.CLIENT, cmd, "200", "success")
+
+	var getUser store.User
+
+	publicId := c.Param("publicId")
+	if utils.IsEmail(publicId) {
+		user, err := s.Users.GetByEmail(c.Context(), publicId)
+		if err != nil {
+			return c.Response(500, err)
+		}
+		getUser = *user
+	} else {
+		user, err := s.Users.GetByID(c.Context(), publicId)
+		if err != nil {
+			return c.Response(500, err)
+		}
+		getUser = *user
+	}
+
+	// var optionAttributes []http_service.RequestAttributes
+	// for i := 1; i <= 1; i++ {
+	// 	optionAttribute := http_service.RequestAttributes{
+	// 		Command: "get_x",
+	// 		Service: "node",
+	// 		Invoke:  "get_all_users",
+	// 		Method:  http.MethodGet,
+	// 		URL:     "http://localhost:3000/x/{id}",
+	// 		Headers: map[string]string{
+	// 			"Content-Type": "application/json",
+	// 		},
+	// 		Params: map[string]string{
+	// 			"id": fmt.Sprintf("%d", i),
+	// 		},
+	// 		Timeout: 10,
+	// 	}
+	// 	optionAttributes = append(optionAttributes, optionAttribute)
+	// }
+
+	// 	_, err := http_service.RequestHttp(c.Context(), optionAttributes)
+
+	return c.Response(200, toProfile(&getUser))
+}
+
+func (s Handler) ListUsers(c ms.IContext) error {
+	initInvoke := ms.GenerateXTid("profile")
+	cmd := "list_users"
+
+	_, summaryLog := c.CommonLog(initInvoke, cmd, "anonymous")
+	summaryLog.AddSuccessBlock(constants.CLIENT, cmd, "200", "success")
+
+	var query ListUsersQuery
+	if err := c.Bind(&query); err != nil {
+		summaryLog.AddErrorBlock(constants.CLIENT, cmd, "400", "invalid_request")
+		return c.Response(400, err)
+	}
+
+	filter := store.UserFilter{
+		Email:    query.Email,
+		Username: query.Username,
+		Limit:    store.DefaultListLimit,
+	}
+	if query.Limit != nil {
+		filter.Limit = min(*query.Limit, store.MaxListLimit)
+	}
+	if query.Offset != nil {
+		filter.Offset = *query.Offset
+	}
+
+	for _, date := range []struct {
+		field string
+		value string
+		dst   **time.Time
+	}{
+		{"created_from", query.CreatedFrom, &filter.CreatedFrom},
+		{"created_to", query.CreatedTo, &filter.CreatedTo},
+	} {
+		if date.value == "" {
+			continue
+		}
+		t, err := parseDate(date.value)
+		if err != nil {
+			summaryLog.AddErrorBlock(constants.CLIENT, cmd, "400", "invalid_request")
+			return c.Response(400, &ms.ValidationError{
+				Message: "invalid_request",
+				Errors:  []ms.FieldError{{Field: date.field, Message: "must be RFC3339 or YYYY-MM-DD"}},
+			})
+		}
+		*date.dst = &t
+	}
+
+	users, total, err := s.Users.List(c.Context(), filter)
+	if err != nil {
+		return c.Response(500, err)
+	}
+
+	resp := ProfileList{
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+		Data:   []Profile{},
+	}
+	for _, user := range users {
+		resp.Data = append(resp.Data, toProfile(user))
+	}
+
+	return c.Response(200, resp)
+}
+
+func (s Handler) CreateUser(c ms.IContext) error {
+	initInvoke := ms.GenerateXTid("profile")
+	cmd := "create_user"
+
+	_, summaryLog := c.CommonLog(initInvoke, cmd, "anonymous")
+	summaryLog.AddSuccessBlock(constants.CLIENT, cmd, "200", "success")
+
+	var body CreateProfile
+	if err := c.Bind(&body); err != nil {
+		summaryLog.AddErrorBlock(constants.CLIENT, cmd, "400", "invalid_request")
+		return c.Response(400, err)
+	}
+	if err := checkDateOfBirth(body.DateOfBirth); err != nil {
+		summaryLog.AddErrorBlock(constants.CLIENT, cmd, "400", "invalid_request")
+		return c.Response(400, err)
+	}
+
+	user := &store.User{
+		Email:        body.Email,
+		Username:     body.Username,
+		FirstName:    body.FirstName,
+		LastName:     body.LastName,
+		DateOfBirth:  body.DateOfBirth,
+		PhoneNumber:  body.PhoneNumber,
+		Gender:       body.Gender,
+		DisplayName:  body.DisplayName,
+		ProfileImage: body.ProfileImage,
+		CreatedBy:    body.CreatedBy,
+	}
+	if err := user.Password.Set(body.Password); err != nil {
+		return c.Response(500, err)
+	}
+
+	ctx := c.Context()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return c.Response(500, err)
+	}
+
+	if err := s.Users.Create(ctx, tx, user); err != nil {
+		tx.Rollback()
+		return c.Response(500, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Response(500, err)
+	}
+
+	return c.Response(201, toProfile(user))
+}
+
+func (s Handler) UpdateUser(c ms.IContext) error {
+	initInvoke := ms.GenerateXTid("profile")
+	cmd := "update_user"
+
+	_, summaryLog := c.CommonLog(initInvoke, cmd, "anonymous")
+	summaryLog.AddSuccessBlock(constants.CLIENT, cmd, "200", "success")
+
+	var patch store.UserPatch
+	if err := c.Bind(&patch); err != nil {
+		summaryLog.AddErrorBlock(constants.CLIENT, cmd, "400", "invalid_request")
+		return c.Response(400, err)
+	}
+
+	if patch.IsEmpty() {
+		summaryLog.AddErrorBlock(constants.CLIENT, cmd, "400", "invalid_request")
+		return c.Response(400, ms.NewError(http.StatusBadRequest, "invalid_request", "no field to update"))
+	}
+	if patch.DateOfBirth != nil {
+		if err := checkDateOfBirth(*patch.DateOfBirth); err != nil {
+			summaryLog.AddErrorBlock(constants.CLIENT, cmd, "400", "invalid_request")
+			return c.Response(400, err)
+		}
+	}
+
+	user, err := s.Users.Update(c.Context(), c.Param("id"), patch)
+	if err != nil {
+		return c.Response(500, err)
+	}
+
+	return c.Response(200, toProfile(user))
+}
+
+func (s Handler) DeleteUser(c ms.IContext) error {
+	initInvoke := ms.GenerateXTid("profile")
+	cmd := "delete_user"
+
+	_, summaryLog := c.CommonLog(initInvoke, cmd, "anonymous")
+	summaryLog.AddSuccessBlock(constants.CLIENT, cmd, "200", "success")
+
+	if err := s.Users.Delete(c.Context(), c.Param("id")); err != nil {
+		return c.Response(500, err)
+	}
+
+	return c.Response(204, nil)
+}
+
+func (s Handler) ActivateUser(c ms.IContext) error {
+	initInvoke := ms.GenerateXTid("profile")
+	cmd := "activate_user"
+
+	_, summaryLog := c.CommonLog(initInvoke, cmd, "anonymous")
+	summaryLog.AddSuccessBlock(constants.CLIENT, cmd, "200", "success")
+
+	if err := s.Users.Activate(c.Context(), c.Param("token")); err != nil {
+		return c.Response(500, err)
+	}
+
+	return c.Response(204, nil)
+}
+
+// checkDateOfBirth returns the validation error of a date of birth that is neither empty nor
+// YYYY-MM-DD, which Postgres would reject as a date.
+func checkDateOfBirth(value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := time.Parse(time.DateOnly, value); err != nil {
+		return &ms.ValidationError{
+			Message: "invalid_request",
+			Errors:  []ms.FieldError{{Field: "date_of_birth", Message: "must be YYYY-MM-DD"}},
+		}
+	}
+	return nil
+}
+
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sing3demons/profile-service/ms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDateOfBirth(t *testing.T) {
+	assert.NoError(t, checkDateOfBirth(""))
+	assert.NoError(t, checkDateOfBirth("1990-05-04"))
+
+	for _, value := range []string{"04/05/1990", "1990-02-30", "1990-05-04T00:00:00Z", "yesterday"} {
+		err := checkDateOfBirth(value)
+		var validation *ms.ValidationError
+		if assert.ErrorAs(t, err, &validation, value) {
+			assert.Equal(t, "date_of_birth", validation.Errors[0].Field)
+		}
+	}
+}
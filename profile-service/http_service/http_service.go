@@ -15,7 +15,15 @@ import (
 	"sync"
 	"time"
 
+	"github.com/sing3demons/profile-service/constants"
 	"github.com/sing3demons/profile-service/logger"
+	"github.com/sing3demons/profile-service/tracing"
+	"github.com/sing3demons/profile-service/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TMap map[string]string
@@ -81,12 +89,15 @@ type ApiResponse struct {
 }
 
 type httpService struct {
+	ctx               context.Context
 	requestAttributes []RequestAttributes
 	detailLog         logger.DetailLog
 	summaryLog        logger.SummaryLog
 }
 
-func RequestHttp(optionAttributes OptionAttributes, detailLog logger.DetailLog, summaryLog logger.SummaryLog) (any, error) {
+// RequestHttp sends the requests of optionAttributes in parallel, they are cancelled with ctx
+// and logged to its detail and summary logs.
+func RequestHttp(ctx context.Context, optionAttributes OptionAttributes) (any, error) {
 	var requestAttributes []RequestAttributes
 	switch attr := optionAttributes.(type) {
 	case []RequestAttributes:
@@ -97,7 +108,9 @@ func RequestHttp(optionAttributes OptionAttributes, detailLog logger.DetailLog,
 		return nil, errors.New("invalid optionAttributes type")
 	}
 
+	detailLog, summaryLog := logger.Log(ctx)
 	service := httpService{
+		ctx:               ctx,
 		requestAttributes: requestAttributes,
 		detailLog:         detailLog,
 		summaryLog:        summaryLog,
@@ -115,7 +128,7 @@ func (svc *httpService) requestHttp() (any, error) {
 
 	for _, attr := range svc.requestAttributes {
 		semaphore <- struct{}{}
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		ctx, cancel := context.WithTimeout(svc.ctx, 15*time.Second)
 		defer cancel()
 
 		wg.Add(1)
@@ -123,8 +136,15 @@ func (svc *httpService) requestHttp() (any, error) {
 			defer wg.Done()
 			defer func() { <-semaphore }()
 
+			ctx, span := tracing.Start(ctx, fmt.Sprintf("%s %s", attr.Method, attr.Command), trace.SpanKindClient,
+				semconv.HTTPRequestMethodKey.String(string(attr.Method)),
+				attribute.String("peer.service", attr.Service),
+			)
+			defer span.End()
+
 			req, err := createRequest(ctx, attr, svc.detailLog, svc.summaryLog)
 			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
 				responseChan <- ApiResponse{
 					Status:     500,
 					attr:       attrDetailLog{Service: attr.Service, Command: attr.Command, Invoke: attr.Invoke, Method: attr.Method},
@@ -141,6 +161,14 @@ func (svc *httpService) requestHttp() (any, error) {
 			}
 			response := executeRequest(client, req, attr)
 
+			span.SetAttributes(semconv.HTTPResponseStatusCode(response.Status))
+			if response.Err != nil {
+				span.RecordError(response.Err)
+				span.SetStatus(codes.Error, response.Err.Error())
+			} else if response.Status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, response.StatusText)
+			}
+
 			// mu.Lock()
 			// defer mu.Unlock()
 			responseChan <- *response
@@ -250,6 +278,10 @@ func createRequest(
 	for key, value := range attr.Headers {
 		req.Header.Set(key, value)
 	}
+	tracing.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if session := utils.SessionFromContext(ctx); session != "" && req.Header.Get(constants.SessionHeader) == "" {
+		req.Header.Set(constants.SessionHeader, session)
+	}
 
 	// Set BasicAuth
 	if attr.Auth != nil {
@@ -0,0 +1,77 @@
+package logger
+
+import "context"
+
+type contextKey struct{}
+
+type contextLogs struct {
+	detail  DetailLog
+	summary SummaryLog
+}
+
+// NewContext returns a copy of ctx carrying the detail and summary logs of the request,
+// the store and clients read them back with Log.
+func NewContext(ctx context.Context, detailLog DetailLog, summaryLog SummaryLog) context.Context {
+	return context.WithValue(ctx, contextKey{}, contextLogs{detail: detailLog, summary: summaryLog})
+}
+
+// Log returns the detail and summary logs carried by ctx. A context without logs, e.g. a
+// background job, gets logs writing nothing so callers never check for nil.
+func Log(ctx context.Context) (DetailLog, SummaryLog) {
+	logs, _ := ctx.Value(contextKey{}).(contextLogs)
+	detailLog, summaryLog := logs.detail, logs.summary
+	if detailLog == nil {
+		detailLog = nopDetailLog{ctx: ctx}
+	}
+	if summaryLog == nil {
+		summaryLog = nopSummaryLog{}
+	}
+	return detailLog, summaryLog
+}
+
+type nopDetailLog struct {
+	ctx context.Context
+}
+
+func (nopDetailLog) IsRawDataEnabled() bool {
+	return false
+}
+
+func (nopDetailLog) AddInputRequest(node, cmd, invoke string, rawData, data interface{}) {}
+
+func (nopDetailLog) AddOutputRequest(node, cmd, invoke string, rawData, data interface{}) {}
+
+func (nopDetailLog) End() {}
+
+func (nopDetailLog) AddInputResponse(node, cmd, invoke string, rawData, data interface{}, protocol, protocolMethod string) {
+}
+
+func (nopDetailLog) AddOutputResponse(node, cmd, invoke string, rawData, data interface{}) {}
+
+func (nopDetailLog) AutoEnd() bool {
+	return false
+}
+
+func (l nopDetailLog) Context() context.Context {
+	return l.ctx
+}
+
+type nopSummaryLog struct{}
+
+func (l nopSummaryLog) New(scenario string) SummaryLog {
+	return l
+}
+
+func (nopSummaryLog) AddField(fieldName string, fieldValue interface{}) {}
+
+func (nopSummaryLog) AddSuccessBlock(node, cmd, resultCode, resultDesc string) {}
+
+func (nopSummaryLog) AddErrorBlock(node, cmd, resultCode, resultDesc string) {}
+
+func (nopSummaryLog) IsEnd() bool {
+	return true
+}
+
+func (nopSummaryLog) End(resultCode, resultDescription string) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFromContext(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	detailLog := NewDetailLog(req, "invoke", "scenario", "", LogConfig{})
+	summaryLog := NewSummaryLog(req, "invoke", "scenario", LogConfig{})
+
+	d, s := Log(NewContext(context.Background(), detailLog, summaryLog))
+	assert.Same(t, detailLog, d)
+	assert.Same(t, summaryLog, s)
+}
+
+func TestLogWithoutLogs(t *testing.T) {
+	ctx := context.WithValue(context.Background(), contextKey{}, "other")
+	d, s := Log(ctx)
+
+	d.AddOutputRequest("node", "cmd", "invoke", nil, "data")
+	d.End()
+	assert.False(t, d.AutoEnd())
+	assert.Equal(t, ctx, d.Context())
+	s.AddSuccessBlock("node", "cmd", "200", "success")
+	assert.True(t, s.IsEnd())
+	assert.NoError(t, s.End("200", "success"))
+}
@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/sing3demons/profile-service/constants"
+	"github.com/sing3demons/profile-service/tracing"
 )
 
 type DetailLog interface {
@@ -20,10 +22,18 @@ type DetailLog interface {
 	AddInputResponse(node, cmd, invoke string, rawData, data interface{}, protocol, protocolMethod string)
 	AddOutputResponse(node, cmd, invoke string, rawData, data interface{})
 	AutoEnd() bool
+	// Context returns the request context, it carries the active trace span.
+	Context() context.Context
 }
 
+// NewDetailLog returns a detail log written in full, see NewLogs for a sampled one.
 func NewDetailLog(req *http.Request, initInvoke, scenario, identity string, conf LogConfig) DetailLog {
+	return newDetailLog(req, initInvoke, scenario, identity, conf)
+}
+
+func newDetailLog(req *http.Request, initInvoke, scenario, identity string, conf LogConfig) *detailLog {
 	session := req.Context().Value(constants.Session)
+	traceID, spanID := tracing.IDs(req.Context())
 
 	host, _ := os.Hostname()
 	data := &detailLog{
@@ -35,9 +45,14 @@ func NewDetailLog(req *http.Request, initInvoke, scenario, identity string, conf
 		InitInvoke:    initInvoke,
 		Scenario:      scenario,
 		Identity:      identity,
+		TraceID:       traceID,
+		SpanID:        spanID,
 		Input:         []InputOutputLog{},
 		Output:        []InputOutputLog{},
 		conf:          conf.Detail,
+		masker:        newMasker(conf.Masking),
+		policy:        conf.policy(),
+		sinks:         conf.Sinks,
 		startTimeDate: time.Now(),
 		timeCounter:   make(map[string]time.Time),
 		req:           req,
@@ -57,8 +72,13 @@ func getInstance() *string {
 	return &instance
 }
 
+func (dl *detailLog) Context() context.Context {
+	return dl.req.Context()
+}
+
 func (dl *detailLog) IsRawDataEnabled() bool {
-	return dl.conf.RawData
+	enabled, _ := dl.policy.rawData()
+	return enabled
 }
 
 func (dl *detailLog) AddInputRequest(node, cmd, invoke string, rawData, data interface{}) {
@@ -120,6 +140,9 @@ func (dl *detailLog) AddOutputResponse(node, cmd, invoke string, rawData, data i
 func (dl *detailLog) addInput(input *logEvent) {
 	dl.mu.Lock()
 	defer dl.mu.Unlock()
+	if dl.summary.ended() {
+		return
+	}
 	now := time.Now()
 	if dl.startTimeDate.IsZero() {
 		dl.startTimeDate = now
@@ -143,7 +166,7 @@ func (dl *detailLog) addInput(input *logEvent) {
 		Protocol: protocolValue,
 		Type:     input.logType,
 		RawData:  dl.isRawDataEnabledIf(input.rawData),
-		Data:     input.data,
+		Data:     dl.masker.mask(input.data),
 		ResTime:  &resTimeString,
 	}
 	dl.Input = append(dl.Input, inputLog)
@@ -170,6 +193,9 @@ func (dl *detailLog) AddOutputRequest(node, cmd, invoke string, rawData, data in
 func (dl *detailLog) AddOutput(out logEvent) {
 	dl.mu.Lock()
 	defer dl.mu.Unlock()
+	if dl.summary.ended() {
+		return
+	}
 	now := time.Now()
 	if out.invoke != "" && out.logType != "res" {
 		dl.timeCounter[out.invoke] = now
@@ -182,12 +208,22 @@ func (dl *detailLog) AddOutput(out logEvent) {
 		Protocol: protocolValue,
 		Type:     out.logType,
 		RawData:  dl.isRawDataEnabledIf(out.rawData),
-		Data:     out.data,
+		Data:     dl.masker.mask(out.data),
 	}
 	dl.Output = append(dl.Output, outputLog)
 }
 
 func (dl *detailLog) End() {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.end()
+}
+
+func (dl *detailLog) end() {
+	if dl.startTimeDate.IsZero() && dl.summary.ended() {
+		// the entries added after the request ended were dropped
+		return
+	}
 	if dl.startTimeDate.IsZero() {
 		log.Fatal("end() called without any input/output")
 	}
@@ -202,6 +238,14 @@ func (dl *detailLog) End() {
 	dl.OutputTimeStamp = outputTimeStamp
 
 	logDetail, _ := json.Marshal(dl)
+	if !dl.sample.hold(logDetail) {
+		dl.write(logDetail)
+	}
+
+	dl.clear()
+}
+
+func (dl *detailLog) write(logDetail []byte) {
 	if dl.conf.LogConsole {
 		os.Stdout.Write(logDetail)
 		os.Stdout.Write([]byte(endOfLine()))
@@ -210,8 +254,7 @@ func (dl *detailLog) End() {
 	if dl.conf.LogFile {
 		dl.conf.LogDetail.Info(string(logDetail))
 	}
-
-	dl.clear()
+	writeSinks(dl.sinks, LogTypeDetail, logDetail)
 }
 
 func (dl *detailLog) buildValueProtocol(protocol, method *string) *string {
@@ -226,6 +269,8 @@ func (dl *detailLog) buildValueProtocol(protocol, method *string) *string {
 }
 
 func (dl *detailLog) AutoEnd() bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
 	if dl.startTimeDate.IsZero() {
 		return false
 	}
@@ -233,13 +278,14 @@ func (dl *detailLog) AutoEnd() bool {
 		return false
 	}
 
-	dl.End()
+	dl.end()
 	return true
 }
 
 func (dl *detailLog) isRawDataEnabledIf(rawData interface{}) interface{} {
-	if dl.conf.RawData {
-		return rawData
+	enabled, limit := dl.policy.rawData()
+	if enabled {
+		return truncateRawData(dl.masker.maskRaw(rawData), limit)
 	}
 	return nil
 }
@@ -259,6 +305,7 @@ func endOfLine() string {
 	return "\n"
 }
 
+// clear resets the entries written by end, dl.mu is held.
 func (dl *detailLog) clear() {
 	dl.ProcessingTime = nil
 	dl.InputTimeStamp = nil
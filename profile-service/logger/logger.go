@@ -13,6 +13,14 @@ type LogConfig struct {
 	Namespace   string
 	Summary     SummaryLogConfig `json:"summary"`
 	Detail      DetailLogConfig  `json:"detail"`
+	// Masking adds rules to DefaultMaskRules for the detail data and raw data and the
+	// summary custom fields.
+	Masking []MaskRule `json:"masking"`
+	// Sinks receive the detail and summary logs whatever LogFile and LogConsole are.
+	Sinks []Sink `json:"-"`
+	// Policy holds the raw data and sampling settings changed at runtime, taken from
+	// Detail.RawData when nil.
+	Policy *Policy `json:"-"`
 }
 
 type SummaryLogConfig struct {
@@ -50,12 +58,19 @@ type detailLog struct {
 	InitInvoke      string               `json:"InitInvoke"`
 	Scenario        string               `json:"Scenario"`
 	Identity        string               `json:"Identity"`
+	TraceID         string               `json:"TraceId,omitempty"`
+	SpanID          string               `json:"SpanId,omitempty"`
 	InputTimeStamp  *string              `json:"InputTimeStamp,omitempty"`
 	Input           []InputOutputLog     `json:"Input"`
 	OutputTimeStamp *string              `json:"OutputTimeStamp,omitempty"`
 	Output          []InputOutputLog     `json:"Output"`
 	ProcessingTime  *string              `json:"ProcessingTime,omitempty"`
 	conf            DetailLogConfig      `json:"-"`
+	masker          *masker              `json:"-"`
+	policy          *Policy              `json:"-"`
+	sample          *sample              `json:"-"`
+	sinks           []Sink               `json:"-"`
+	summary         *summaryLog          `json:"-"`
 	startTimeDate   time.Time            `json:"-"`
 	inputTime       *time.Time           `json:"-"`
 	outputTime      *time.Time           `json:"-"`
@@ -81,10 +96,14 @@ type summaryLog struct {
 	requestTime   *time.Time
 	session       string
 	initInvoke    string
+	traceID       string
+	spanID        string
 	cmd           string
 	blockDetail   []BlockDetail
 	optionalField OptionalFields
 	conf          LogConfig
+	failed        bool
+	sample        *sample
 }
 
 type SummaryResult struct {
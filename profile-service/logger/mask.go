@@ -0,0 +1,196 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaskType is how a masked value is written in the logs.
+type MaskType string
+
+const (
+	// MaskFull replaces the value with ******.
+	MaskFull MaskType = "full"
+	// MaskPartial keeps the first 2 characters, e.g. 08XXXXXXXX.
+	MaskPartial MaskType = "partial"
+	// MaskEmail keeps the first 3 characters of the local part and the domain, e.g. johXXX@mail.com.
+	MaskEmail MaskType = "email"
+	// MaskHash replaces the value with its sha256, equal values can still be correlated.
+	MaskHash MaskType = "hash"
+)
+
+const maskedValue = "******"
+
+// MaskRule masks the values of Fields, names matched at any depth, and of Paths, dotted
+// paths from the root of the logged data where * matches any key, e.g. body.*.email.
+// Arrays are transparent: items.card masks the card of every item. Names are matched
+// ignoring case, underscores and dashes, so access_token also matches accessToken.
+type MaskRule struct {
+	Fields []string `json:"fields"`
+	Paths  []string `json:"paths"`
+	Type   MaskType `json:"type"`
+}
+
+// DefaultMaskRules hide the credentials, they apply whatever LogConfig.Masking holds.
+var DefaultMaskRules = []MaskRule{
+	{
+		Fields: []string{"password", "access_token", "refresh_token", "authorization", "client_secret"},
+		Type:   MaskFull,
+	},
+}
+
+var emailPattern = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+type maskPath struct {
+	segments []string
+	maskType MaskType
+}
+
+// masker applies the default and configured rules to the logged data.
+type masker struct {
+	fields map[string]MaskType
+	paths  []maskPath
+}
+
+func newMasker(rules []MaskRule) *masker {
+	m := &masker{fields: map[string]MaskType{}}
+	for _, rule := range append(append([]MaskRule{}, DefaultMaskRules...), rules...) {
+		maskType := rule.Type
+		if maskType == "" {
+			maskType = MaskFull
+		}
+		for _, field := range rule.Fields {
+			m.fields[normalizeField(field)] = maskType
+		}
+		for _, path := range rule.Paths {
+			segments := strings.Split(path, ".")
+			for i, segment := range segments {
+				segments[i] = normalizeField(segment)
+			}
+			m.paths = append(m.paths, maskPath{segments: segments, maskType: maskType})
+		}
+	}
+	return m
+}
+
+// mask returns data with the matching values masked, structs come back as their JSON form.
+func (m *masker) mask(data interface{}) interface{} {
+	if m == nil || data == nil {
+		return data
+	}
+	return m.maskValue(ToStruct(data), nil)
+}
+
+// maskRaw masks a JSON raw data, any other string is kept as is.
+func (m *masker) maskRaw(rawData interface{}) interface{} {
+	if m == nil {
+		return rawData
+	}
+	raw, ok := rawData.(string)
+	if !ok {
+		return m.mask(rawData)
+	}
+
+	trimmed := strings.TrimSpace(raw)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return raw
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(trimmed), &data); err != nil {
+		return raw
+	}
+	return ToJson(m.maskValue(data, nil))
+}
+
+func (m *masker) maskValue(value interface{}, path []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := append(path[:len(path):len(path)], normalizeField(key))
+			if maskType, ok := m.rule(itemPath); ok {
+				masked[key] = maskWith(maskType, item)
+				continue
+			}
+			masked[key] = m.maskValue(item, itemPath)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = m.maskValue(item, path)
+		}
+		return masked
+	default:
+		return value
+	}
+}
+
+func (m *masker) rule(path []string) (MaskType, bool) {
+	if maskType, ok := m.fields[path[len(path)-1]]; ok {
+		return maskType, true
+	}
+	for _, p := range m.paths {
+		if matchPath(p.segments, path) {
+			return p.maskType, true
+		}
+	}
+	return "", false
+}
+
+func matchPath(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i, segment := range pattern {
+		if segment != "*" && segment != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func maskWith(maskType MaskType, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		s = ToJson(value)
+	}
+
+	switch maskType {
+	case MaskPartial:
+		return maskPartial(s, 2)
+	case MaskEmail:
+		if local, domain, found := strings.Cut(s, "@"); found && emailPattern.MatchString(s) {
+			return maskPartial(local, 3) + "@" + domain
+		}
+		return maskPartial(s, 2)
+	case MaskHash:
+		sum := sha256.Sum256([]byte(s))
+		return fmt.Sprintf("sha256:%s", hex.EncodeToString(sum[:]))
+	default:
+		return maskedValue
+	}
+}
+
+// maskPartial keeps the first keep characters, at least one is masked.
+func maskPartial(s string, keep int) string {
+	runes := []rune(s)
+	if keep >= len(runes) {
+		keep = len(runes) - 1
+	}
+	if keep < 0 {
+		return s
+	}
+	return string(runes[:keep]) + strings.Repeat("X", len(runes)-keep)
+}
+
+func normalizeField(s string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(s))
+}
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskDefaultRules(t *testing.T) {
+	m := newMasker(nil)
+
+	type basicAuth struct {
+		Username string
+		Password string
+	}
+	masked := m.mask(map[string]any{
+		"headers": map[string]any{"Authorization": []string{"Bearer abc"}},
+		"body":    map[string]any{"email": "john@mail.com", "password": "p4ss"},
+		"auth":    basicAuth{Username: "svc", Password: "secret"},
+		"tokens":  []any{map[string]any{"accessToken": "a", "refresh_token": "r"}},
+	})
+
+	assert.Equal(t, map[string]any{
+		"headers": map[string]any{"Authorization": maskedValue},
+		"body":    map[string]any{"email": "john@mail.com", "password": maskedValue},
+		"auth":    map[string]any{"Username": "svc", "Password": maskedValue},
+		"tokens":  []any{map[string]any{"accessToken": maskedValue, "refresh_token": maskedValue}},
+	}, masked)
+}
+
+func TestMaskConfiguredRules(t *testing.T) {
+	m := newMasker([]MaskRule{
+		{Fields: []string{"email"}, Type: MaskEmail},
+		{Fields: []string{"phone_number"}, Type: MaskPartial},
+		{Paths: []string{"items.*.card"}, Type: MaskHash},
+	})
+
+	masked := m.mask(map[string]any{
+		"user":  map[string]any{"email": "john@mail.com", "phoneNumber": "0812345678"},
+		"items": []any{map[string]any{"visa": map[string]any{"card": "4111"}}},
+		"card":  "kept",
+	}).(map[string]any)
+
+	user := masked["user"].(map[string]any)
+	assert.Equal(t, "johX@mail.com", user["email"])
+	assert.Equal(t, "08XXXXXXXX", user["phoneNumber"])
+	card := masked["items"].([]any)[0].(map[string]any)["visa"].(map[string]any)["card"]
+	assert.True(t, strings.HasPrefix(card.(string), "sha256:"))
+	assert.Equal(t, "kept", masked["card"])
+}
+
+func TestMaskRaw(t *testing.T) {
+	m := newMasker(nil)
+
+	assert.JSONEq(t, `{"username":"john","password":"******"}`, m.maskRaw(`{"username":"john","password":"p4ss"}`).(string))
+	assert.Equal(t, "plain text", m.maskRaw("plain text"))
+	assert.Equal(t, "{broken", m.maskRaw("{broken"))
+	assert.Nil(t, m.mask(nil))
+}
+
+func TestMaskWith(t *testing.T) {
+	assert.Equal(t, maskedValue, maskWith(MaskFull, "secret"))
+	assert.Equal(t, "aX", maskWith(MaskPartial, "ab"))
+	assert.Equal(t, "abXXX", maskWith(MaskPartial, "abcde"))
+	assert.Equal(t, "abXX", maskWith(MaskEmail, "abcd"))
+	assert.Equal(t, maskWith(MaskHash, "same"), maskWith(MaskHash, "same"))
+	assert.Nil(t, maskWith(MaskFull, nil))
+}
@@ -0,0 +1,193 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxHeldDetailLogs bounds the detail logs held for a request until its summary ends.
+const maxHeldDetailLogs = 100
+
+// SamplingRule writes Rate, from 0 to 1, of the successful detail logs of the scenarios
+// matching Scenario, a path.Match pattern such as get_user_*. The detail logs of failed
+// requests are always written.
+type SamplingRule struct {
+	Scenario string  `json:"scenario"`
+	Rate     float64 `json:"rate"`
+}
+
+// PolicySettings are the detail log settings that can change while the service runs.
+type PolicySettings struct {
+	RawData bool `json:"rawData"`
+	// RawDataLimit truncates the raw data longer than this many bytes, no limit when zero.
+	RawDataLimit int `json:"rawDataLimit"`
+	// Sampling rules are tried in order and the first matching the scenario applies,
+	// the detail logs of a scenario matching none are all written.
+	Sampling []SamplingRule `json:"sampling"`
+}
+
+// Validate reports the negative limit, invalid patterns and rates out of range.
+func (s PolicySettings) Validate() error {
+	var errs []error
+	if s.RawDataLimit < 0 {
+		errs = append(errs, errors.New("raw data limit must not be negative"))
+	}
+	for _, rule := range s.Sampling {
+		if _, err := path.Match(rule.Scenario, ""); err != nil {
+			errs = append(errs, fmt.Errorf("sampling scenario %q: %w", rule.Scenario, err))
+		}
+		if rule.Rate < 0 || rule.Rate > 1 {
+			errs = append(errs, fmt.Errorf("sampling rate of %q must be between 0 and 1", rule.Scenario))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Policy holds the PolicySettings shared by every log of the service, see LogConfig.Policy.
+type Policy struct {
+	mu       sync.RWMutex
+	settings PolicySettings
+}
+
+// NewPolicy returns a policy applying settings, they are expected to be valid.
+func NewPolicy(settings PolicySettings) *Policy {
+	return &Policy{settings: settings}
+}
+
+// Settings returns a copy of the current settings.
+func (p *Policy) Settings() PolicySettings {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	settings := p.settings
+	settings.Sampling = append([]SamplingRule{}, p.settings.Sampling...)
+	return settings
+}
+
+// Update replaces the settings, the logs started before keep their sampling decision.
+func (p *Policy) Update(settings PolicySettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	settings.Sampling = append([]SamplingRule{}, settings.Sampling...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settings = settings
+	return nil
+}
+
+func (p *Policy) rawData() (bool, int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.settings.RawData, p.settings.RawDataLimit
+}
+
+// sampled draws whether the detail logs of a successful scenario are written.
+func (p *Policy) sampled(scenario string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, rule := range p.settings.Sampling {
+		if matched, _ := path.Match(rule.Scenario, scenario); matched {
+			return rule.Rate >= 1 || rand.Float64() < rule.Rate
+		}
+	}
+	return true
+}
+
+// policy returns conf.Policy, or a fixed policy from the detail config when there is none.
+func (conf LogConfig) policy() *Policy {
+	if conf.Policy != nil {
+		return conf.Policy
+	}
+	return NewPolicy(PolicySettings{RawData: conf.Detail.RawData})
+}
+
+// NewLogs returns the detail and summary logs of a request, its detail logs are sampled by
+// the policy of conf: a request sampled out holds them until the summary ends and writes
+// them only when it failed.
+func NewLogs(req *http.Request, initInvoke, scenario, identity string, conf LogConfig) (DetailLog, SummaryLog) {
+	detailLog := newDetailLog(req, initInvoke, scenario, identity, conf)
+	summaryLog := newSummaryLog(req, initInvoke, scenario, conf)
+	// the entries added once the request ended, e.g. a late delivery report, are dropped
+	detailLog.summary = summaryLog
+	if !detailLog.policy.sampled(scenario) {
+		s := &sample{write: detailLog.write}
+		detailLog.sample, summaryLog.sample = s, s
+	}
+	return detailLog, summaryLog
+}
+
+// sample holds the detail logs of a request sampled out until its summary ends.
+type sample struct {
+	mu      sync.Mutex
+	write   func(entry []byte)
+	keep    bool
+	settled bool
+	held    [][]byte
+}
+
+// hold reports whether entry was taken by the sample, held or dropped, rather than to be written.
+func (s *sample) hold(entry []byte) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.keep:
+		return false
+	case !s.settled && len(s.held) < maxHeldDetailLogs:
+		s.held = append(s.held, entry)
+	}
+	return true
+}
+
+// settle writes the held detail logs of a failed request and drops the others.
+func (s *sample) settle(failed bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.settled {
+		return
+	}
+	s.settled = true
+	s.keep = failed
+	if failed {
+		for _, entry := range s.held {
+			s.write(entry)
+		}
+	}
+	s.held = nil
+}
+
+// successCode reports whether resultCode is a 1xx to 3xx status, e.g. 200 or 20000.
+func successCode(resultCode string) bool {
+	return resultCode != "" && resultCode[0] >= '1' && resultCode[0] <= '3'
+}
+
+// truncateRawData cuts rawData longer than limit bytes and tells how many bytes were cut.
+func truncateRawData(rawData interface{}, limit int) interface{} {
+	if limit <= 0 || rawData == nil {
+		return rawData
+	}
+	s, ok := rawData.(string)
+	if !ok {
+		s = ToJson(rawData)
+	}
+	if len(s) <= limit {
+		return rawData
+	}
+
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", s[:cut], len(s)-cut)
+}
@@ -0,0 +1,97 @@
+package logger
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordSink struct {
+	mu      sync.Mutex
+	entries map[string][]string
+}
+
+func (s *recordSink) Write(logType string, entry []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = map[string][]string{}
+	}
+	s.entries[logType] = append(s.entries[logType], string(entry))
+}
+
+func TestPolicySampled(t *testing.T) {
+	p := NewPolicy(PolicySettings{Sampling: []SamplingRule{
+		{Scenario: "get_user_*", Rate: 0},
+		{Scenario: "*", Rate: 1},
+	}})
+	assert.False(t, p.sampled("get_user_by_id"))
+	assert.True(t, p.sampled("create_user"))
+
+	assert.NoError(t, p.Update(PolicySettings{}))
+	assert.True(t, p.sampled("get_user_by_id"))
+
+	err := p.Update(PolicySettings{RawDataLimit: -1, Sampling: []SamplingRule{{Scenario: "[", Rate: 2}}})
+	assert.ErrorContains(t, err, "raw data limit")
+	assert.ErrorContains(t, err, "sampling scenario")
+	assert.ErrorContains(t, err, "between 0 and 1")
+}
+
+func TestNewLogsSampledOut(t *testing.T) {
+	sink := &recordSink{}
+	conf := LogConfig{
+		Sinks:  []Sink{sink},
+		Policy: NewPolicy(PolicySettings{Sampling: []SamplingRule{{Scenario: "get_user_by_id", Rate: 0}}}),
+	}
+	req := httptest.NewRequest("GET", "/users/1", nil)
+
+	// a successful request sampled out writes its summary only
+	detailLog, summaryLog := NewLogs(req, "invoke", "get_user_by_id", "", conf)
+	detailLog.AddOutputRequest("postgres", "get_user_by_id", "invoke", nil, "query")
+	detailLog.End()
+	assert.NoError(t, summaryLog.End("200", "success"))
+	assert.Empty(t, sink.entries[LogTypeDetail])
+	assert.Len(t, sink.entries[LogTypeSummary], 1)
+
+	// a failed one writes its held detail logs, the entries added once it ended are dropped
+	detailLog, summaryLog = NewLogs(req, "invoke", "get_user_by_id", "", conf)
+	detailLog.AddOutputRequest("postgres", "get_user_by_id", "invoke", nil, "query")
+	detailLog.End()
+	summaryLog.AddErrorBlock("postgres", "get_user_by_id", "500", "timeout")
+	assert.NoError(t, summaryLog.End("500", "timeout"))
+	detailLog.AddInputRequest("postgres", "get_user_by_id", "invoke", nil, "timeout")
+	detailLog.End()
+	assert.Len(t, sink.entries[LogTypeDetail], 1)
+
+	// other scenarios are not sampled
+	detailLog, _ = NewLogs(req, "invoke", "create_user", "", conf)
+	detailLog.AddOutputRequest("postgres", "create_user", "invoke", nil, "query")
+	detailLog.End()
+	assert.Len(t, sink.entries[LogTypeDetail], 2)
+}
+
+func TestRawDataLimit(t *testing.T) {
+	conf := LogConfig{Policy: NewPolicy(PolicySettings{RawData: true, RawDataLimit: 10})}
+	detailLog := newDetailLog(httptest.NewRequest("GET", "/", nil), "invoke", "scenario", "", conf)
+	assert.True(t, detailLog.IsRawDataEnabled())
+
+	assert.Equal(t, "0123456789...[truncated 5 bytes]", detailLog.isRawDataEnabledIf("0123456789abcde"))
+	assert.Equal(t, "short", detailLog.isRawDataEnabledIf("short"))
+	assert.Equal(t, `{"name":"j...[truncated 10 bytes]`, detailLog.isRawDataEnabledIf(map[string]string{"name": "johnsmith"}))
+
+	// a multi-byte rune is not cut in half
+	truncated := truncateRawData(strings.Repeat("é", 6), 5).(string)
+	assert.Equal(t, "éé...[truncated 8 bytes]", truncated)
+
+	assert.NoError(t, conf.Policy.Update(PolicySettings{}))
+	assert.Nil(t, detailLog.isRawDataEnabledIf("0123456789abcde"))
+}
+
+func TestSuccessCode(t *testing.T) {
+	for code, want := range map[string]bool{"200": true, "20000": true, "302": true, "404": false, "500": false, "": false, "not_found": false} {
+		assert.Equal(t, want, successCode(code), code)
+	}
+}
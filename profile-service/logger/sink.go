@@ -0,0 +1,21 @@
+package logger
+
+// Log types passed to Sink.Write.
+const (
+	LogTypeDetail  = "detail"
+	LogTypeSummary = "summary"
+)
+
+// Sink receives every detail and summary log next to the console and file outputs,
+// e.g. to ship them to logstash.
+type Sink interface {
+	// Write is called with the JSON encoded log once it ended, it must not block the request:
+	// a sink buffers the entry or drops it.
+	Write(logType string, entry []byte)
+}
+
+func writeSinks(sinks []Sink, logType string, entry []byte) {
+	for _, sink := range sinks {
+		sink.Write(logType, entry)
+	}
+}
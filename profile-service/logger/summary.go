@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/sing3demons/profile-service/constants"
+	"github.com/sing3demons/profile-service/tracing"
 )
 
 type SummaryLog interface {
@@ -21,8 +22,13 @@ type SummaryLog interface {
 }
 
 func NewSummaryLog(req *http.Request, initInvoke string, cmd string, conf LogConfig) SummaryLog {
+	return newSummaryLog(req, initInvoke, cmd, conf)
+}
+
+func newSummaryLog(req *http.Request, initInvoke string, cmd string, conf LogConfig) *summaryLog {
 
 	session := req.Context().Value(constants.Session)
+	traceID, spanID := tracing.IDs(req.Context())
 
 	currentTime := time.Now()
 	if initInvoke == "" {
@@ -32,6 +38,8 @@ func NewSummaryLog(req *http.Request, initInvoke string, cmd string, conf LogCon
 		requestTime: &currentTime,
 		session:     fmt.Sprintf("%v", session),
 		initInvoke:  initInvoke,
+		traceID:     traceID,
+		spanID:      spanID,
 		cmd:         cmd,
 		conf:        conf,
 	}
@@ -42,6 +50,7 @@ func (sl *summaryLog) New(scenario string) SummaryLog {
 	defer sl.mu.Unlock()
 	sl.cmd = scenario
 	sl.blockDetail = []BlockDetail{}
+	sl.failed = false
 	return sl
 }
 
@@ -59,6 +68,11 @@ func (sl *summaryLog) AddSuccessBlock(node, cmd, resultCode, resultDesc string)
 }
 
 func (sl *summaryLog) AddErrorBlock(node, cmd, resultCode, resultDesc string) {
+	sl.mu.Lock()
+	if sl.requestTime != nil {
+		sl.failed = true
+	}
+	sl.mu.Unlock()
 	sl.addBlock(node, cmd, resultCode, resultDesc)
 }
 
@@ -68,6 +82,11 @@ func (sl *summaryLog) IsEnd() bool {
 	return sl.requestTime == nil
 }
 
+// ended reports whether the summary of a request ended, false without a summary.
+func (sl *summaryLog) ended() bool {
+	return sl != nil && sl.IsEnd()
+}
+
 func (sl *summaryLog) End(resultCode, resultDescription string) error {
 	sl.mu.Lock()
 	defer sl.mu.Unlock()
@@ -76,12 +95,18 @@ func (sl *summaryLog) End(resultCode, resultDescription string) error {
 	}
 	sl.process(resultCode, resultDescription)
 	sl.requestTime = nil
+	// the held detail logs are written when the request failed
+	sl.sample.settle(sl.failed || !successCode(resultCode))
 	return nil
 }
 
+// addBlock drops the blocks added once the summary ended.
 func (sl *summaryLog) addBlock(node, cmd, resultCode, resultDesc string) {
 	sl.mu.Lock()
 	defer sl.mu.Unlock()
+	if sl.requestTime == nil {
+		return
+	}
 
 	for i := range sl.blockDetail {
 		if sl.blockDetail[i].Node == node && sl.blockDetail[i].Cmd == cmd {
@@ -141,8 +166,13 @@ func (sl *summaryLog) process(responseResult, responseDesc string) {
 		"ProcessTime":         fmt.Sprintf("%d ms", elapsed.Milliseconds()),
 	}
 
+	if sl.traceID != "" {
+		logEntry["TraceId"] = sl.traceID
+		logEntry["SpanId"] = sl.spanID
+	}
+
 	if sl.optionalField != nil {
-		logEntry["CustomDesc"] = sl.optionalField
+		logEntry["CustomDesc"] = newMasker(sl.conf.Masking).mask(sl.optionalField)
 	}
 
 	b, _ := json.Marshal(logEntry)
@@ -154,7 +184,7 @@ func (sl *summaryLog) process(responseResult, responseDesc string) {
 	if sl.conf.Summary.LogFile {
 		sl.conf.Summary.LogSummary.Info(string(b))
 	}
-
+	writeSinks(sl.conf.Sinks, LogTypeSummary, b)
 }
 
 func getHostname() string {
@@ -1,37 +1,33 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/sing3demons/profile-service/constants"
+	"github.com/sing3demons/profile-service/logger"
+	"github.com/sing3demons/profile-service/migrations"
 	"github.com/sing3demons/profile-service/ms"
 	"github.com/sing3demons/profile-service/store"
 	"github.com/sing3demons/profile-service/template"
 	"github.com/sing3demons/profile-service/utils"
 )
 
-type Profile struct {
-	ID           string `json:"id"`
-	Href         string `json:"href,omitempty"`
-	Email        string `json:"email,omitempty"`
-	UserName     string `json:"username,omitempty"`
-	Password     string `json:"password,omitempty"`
-	FirstName    string `json:"first_name,omitempty"`
-	LastName     string `json:"last_name,omitempty"`
-	DateOfBirth  string `json:"date_of_birth,omitempty"`
-	PhoneNumber  string `json:"phone_number,omitempty"`
-	Gender       string `json:"gender,omitempty"`
-	CreatedAt    string `json:"created_at,omitempty"`
-	UpdatedAt    string `json:"updated_at,omitempty"`
-	CreatedBy    string `json:"created_by,omitempty"`
-	UpdatedBy    string `json:"updated_by,omitempty"`
-	DisplayName  string `json:"display_name,omitempty"`
-	ProfileImage string `json:"profile_image,omitempty"`
-}
+const (
+	mailFrom      = "dev@test.com"
+	invitationExp = 3 * 24 * time.Hour
+	// mailTopic asks the service.send_mail consumer for the invitation mail of a user
+	mailTopic = "service.send_mail"
+)
+
+// activationURL is the page of the web app the confirmation mail links to, %s is the token.
+// Clicking the link is a GET, the page activates the account with PUT /users/activate/{token}.
+var activationURL = "http://localhost:3000/activate/%s"
 
 func init() {
 	// load env
@@ -41,16 +37,37 @@ func init() {
 		projectName := utils.ProjectName()
 		os.Setenv("SERVICE_NAME", projectName)
 	}
+	if url := os.Getenv("ACTIVATION_URL"); url != "" {
+		activationURL = url
+	}
 }
 
 func main() {
-	app := ms.NewApplication(ms.Config{
+	// defaults, overridden by the -config file, the environment and the flags
+	cfg, args, err := ms.LoadConfig(ms.Config{
 		Addr: "8080",
 		Env:  "local",
 		Name: utils.ProjectName(),
+		// the load balancer polls /readyz every few seconds
+		DrainDelay: 5 * time.Second,
 		KafkaCfg: ms.KafkaConfig{
-			Brokers: "localhost:29092",
-			GroupID: "profile-service",
+			Brokers:      "localhost:29092",
+			GroupID:      "profile-service",
+			ClientID:     "profile-service",
+			ManualCommit: true,
+			Retry: map[string]ms.RetryPolicy{
+				"service.register": {
+					MaxRetries: 2,
+					Backoff:    time.Second,
+					Delays:     []time.Duration{time.Minute, 10 * time.Minute},
+				},
+				// the user only gets the activation link once the mail server takes it
+				mailTopic: {
+					MaxRetries: 2,
+					Backoff:    5 * time.Second,
+					Delays:     []time.Duration{time.Minute, 10 * time.Minute, time.Hour},
+				},
+			},
 		},
 		Db: ms.DbConfig{
 			Addr:         os.Getenv("DATABASE_URL"),
@@ -58,6 +75,11 @@ func main() {
 			MaxIdleConns: 10,
 			MaxIdleTime:  "5s",
 			Driver:       "postgres",
+			Migrations:   migrations.FS,
+			AutoMigrate:  true,
+		},
+		Tracing: ms.TracingConfig{
+			Endpoint: os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
 		},
 		LogConfig: ms.LogConfig{
 			ProjectName: "profile-service",
@@ -65,14 +87,34 @@ func main() {
 				LogFile: true,
 			},
 			Detail: ms.DetailLogConfig{
-				RawData:    true,
-				LogFile:    true,
-				LogConsole: false,
+				RawData:      true,
+				LogFile:      true,
+				LogConsole:   false,
+				RawDataLimit: 4096,
+				// reads are most of the traffic, their failures are still all logged
+				Sampling: []logger.SamplingRule{
+					{Scenario: "get_user_by_id", Rate: 0.05},
+					{Scenario: "list_users", Rate: 0.05},
+				},
+				Rotation: ms.LogRotation{
+					MaxSizeMB:      500,
+					Interval:       ms.RotateHourly,
+					MaxAgeDays:     3,
+					MaxTotalSizeMB: 10240,
+					Compress:       true,
+				},
 			},
 			Summary: ms.SummaryLogConfig{
 				RawData:    true,
 				LogFile:    true,
 				LogConsole: true,
+				Rotation: ms.LogRotation{
+					MaxSizeMB:      500,
+					Interval:       ms.RotateDaily,
+					MaxAgeDays:     7,
+					MaxTotalSizeMB: 5120,
+					Compress:       true,
+				},
 			},
 		},
 		MailServer: ms.MailServer{
@@ -80,11 +122,44 @@ func main() {
 			Port:   1025,
 			Secure: false,
 		},
-	})
+	}, os.Args[1:])
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	app := ms.NewApplication(cfg)
+	app.Log("Config", cfg.String())
+
+	// profile-service [flags] migrate up|down [steps|all]|status
+	if len(args) > 0 && args[0] == "migrate" {
+		err := app.Migrate(args[1:]...)
+		app.CleanUp()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// profile-service [flags] dlq replay <topic> [limit]
+	if len(args) > 1 && args[0] == "dlq" && args[1] == "replay" {
+		if len(args) < 3 {
+			log.Fatal("usage: dlq replay <topic> [limit]")
+		}
+		limit := 0
+		if len(args) > 3 {
+			limit, _ = strconv.Atoi(args[3])
+		}
+		replayed, err := app.ReplayDeadLetters(args[2], limit)
+		app.CleanUp()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("replayed %d message(s) from %s\n", replayed, args[2])
+		return
+	}
 
 	app.POST("/mail", func(ctx ms.IContext) error {
 		fmt.Println("send mail")
-		payload := ctx.ReadInput()
 
 		cmd := "send_mail"
 		node := "consume"
@@ -95,11 +170,10 @@ func main() {
 		summaryLog.AddSuccessBlock(node, cmd, "200", "success")
 
 		var body ms.Message
-		if err := mapToStruct(payload.Body, &body); err != nil {
+		if err := ctx.Bind(&body); err != nil {
 			summaryLog.AddErrorBlock(node, cmd, "400", "invalid_request")
 			summaryLog.AddField("error", err.Error())
-			return ctx.Response(400, err.Error())
-
+			return ctx.Response(400, err)
 		}
 
 		ctx.SendMail(ms.Message{
@@ -114,116 +188,135 @@ func main() {
 
 	producer := app.NewProducer()
 
-	// Create table
-	createTableQuery := `
-		CREATE TABLE IF NOT EXISTS Profile (
-			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-			email VARCHAR(255) UNIQUE,
-			username VARCHAR(255) UNIQUE,
-			password VARCHAR(255),
-			first_name VARCHAR(100),
-			last_name VARCHAR(100),
-			date_of_birth DATE,
-			phone_number VARCHAR(15),
-			gender VARCHAR(50),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			created_by VARCHAR(100),
-			updated_by VARCHAR(100),
-			display_name VARCHAR(255),
-			profile_image TEXT
-		);
-		`
-
-	conn := app.ConnDatabase(createTableQuery)
+	conn := app.ConnDatabase()
+	app.StartOutboxRelay(producer, ms.OutboxConfig{})
 
 	s := store.NewStorer(conn)
+	mapStoreErrors(app)
 
 	// handler
-	h := Handler{s}
+	h := Handler{Storer: s, db: conn}
 
+	app.GET("/users", h.ListUsers)
+	app.POST("/users", h.CreateUser)
 	// get by id
 	app.GET("/users/{publicId}", h.GetUserByPublicId)
+	app.PATCH("/users/{id}", h.UpdateUser)
+	app.PUT("/users/activate/{token}", h.ActivateUser)
+	app.DELETE("/users/{id}", h.DeleteUser)
 
+	// Deprecated: the health check before /healthz and /readyz, kept for the callers still using it.
 	app.POST("/health", func(ctx ms.IContext) error {
 		return ctx.Response(200, "OK")
 	})
 
-	app.Consume("service.auth", func(cc ms.IContext) error {
-		fmt.Println("consume xxx", cc)
-		return nil
-	})
-
-	app.Consume("service.register", func(ctx ms.IContext) error {
-		payload := ctx.ReadInput()
-
-		cmd := "register"
-		node := "consume"
-		initInvoke := ms.GenerateXTid("profile")
-		scenario := "service.register"
-
-		detailLog, summaryLog := ctx.CommonLog(initInvoke, scenario, "anonymous")
-
-		summaryLog.AddSuccessBlock(node, cmd, "200", "success")
-
-		var body Register
-		if err := mapToStruct(payload.Body, &body); err != nil {
-			summaryLog.AddErrorBlock(node, cmd, "400", "invalid_request")
-			summaryLog.AddField("error", err.Error())
-			return ctx.Response(400, err.Error())
-
-		}
-
-		c := context.Background()
-		tx, _ := conn.BeginTx(c, nil)
-		p := store.User{}
-		p.Password.Set(body.Password)
-		user := &store.User{
-			Username: body.Username,
-			Email:    body.Email,
-			Password: p.Password,
-		}
-		s.Users.Create(c, tx, user, detailLog, summaryLog)
-
-		tx.Commit()
-
-		producer.SendMessage("service.verify", "", user, detailLog, summaryLog)
-
-		// ctx.SendMail(ms.Message{
-		// 	From:    "dev@test.com",
-		// 	To:      body.Email,
-		// 	Subject: "Register Success",
-		// 	Body:    template.ConfirmEmailMessage("http://localhost:8080/confirm"),
-		// })
-
-		return ctx.Response(200, "success")
+	// one consumer for every service topic, sending the confirmation mail is slow so
+	// registrations with different keys run side by side
+	app.ConsumeRoutes(map[string]ms.ServiceHandleFunc{
+		"service.auth": func(cc ms.IContext) error {
+			fmt.Println("consume xxx", cc)
+			return nil
+		},
+		"service.register": func(ctx ms.IContext) error {
+
+			cmd := "register"
+			node := "consume"
+			initInvoke := ms.GenerateXTid("profile")
+			scenario := "service.register"
+
+			_, summaryLog := ctx.CommonLog(initInvoke, scenario, "anonymous")
+
+			summaryLog.AddSuccessBlock(node, cmd, "200", "success")
+
+			var body Register
+			if err := ctx.Bind(&body); err != nil {
+				summaryLog.AddErrorBlock(node, cmd, "400", "invalid_request")
+				summaryLog.AddField("error", err.Error())
+				return ctx.Response(400, err)
+			}
+
+			c := ctx.Context()
+			user := &store.User{
+				Username: body.Username,
+				Email:    body.Email,
+			}
+			if err := user.Password.Set(body.Password); err != nil {
+				return ctx.Response(500, err)
+			}
+
+			// the invitation mail is asked for through the outbox with the user, a failed send is
+			// retried by the service.send_mail consumer instead of leaving an account that cannot be activated
+			err := s.Users.CreateAndInvite(c, user, func(tx *sql.Tx) error {
+				if err := ms.EnqueueOutbox(c, tx, "service.verify", user.ID, user); err != nil {
+					return err
+				}
+				return ms.EnqueueOutbox(c, tx, mailTopic, user.ID, Invitation{UserID: user.ID})
+			})
+			if err != nil {
+				if errors.Is(err, store.ErrDuplicateEmail) || errors.Is(err, store.ErrDuplicateUsername) {
+					return ctx.Response(409, err)
+				}
+				// the returned error ends the logs and hands the message over to the service.register retry policy
+				return err
+			}
+
+			return ctx.Response(200, "success")
 
-	})
+		},
+		mailTopic: func(ctx ms.IContext) error {
+			cmd := "send_mail"
+			node := "consume"
+			initInvoke := ms.GenerateXTid("profile")
+
+			_, summaryLog := ctx.CommonLog(initInvoke, mailTopic, "anonymous")
+
+			var body Invitation
+			if err := ctx.Bind(&body); err != nil {
+				summaryLog.AddErrorBlock(node, cmd, "400", "invalid_request")
+				summaryLog.AddField("error", err.Error())
+				return ctx.Response(400, err)
+			}
+
+			// the token is issued here so it is neither in the outbox nor in the kafka message,
+			// a retry issues a new one
+			token := utils.NewUUID()
+			user, err := s.Users.Invite(ctx.Context(), body.UserID, token, invitationExp)
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				return ctx.Response(404, err)
+			case errors.Is(err, store.ErrAlreadyActive):
+				return ctx.Response(409, err)
+			case err != nil:
+				return err
+			}
+
+			// the returned error hands the message over to the service.send_mail retry policy
+			if err := ctx.SendMail(ms.Message{
+				From:    mailFrom,
+				To:      user.Email,
+				Subject: "Confirm your email address",
+				Body:    template.ConfirmEmailMessage(fmt.Sprintf(activationURL, token)),
+			}); err != nil {
+				return err
+			}
+			summaryLog.AddSuccessBlock(node, cmd, "200", "success")
+			return ctx.Response(200, "success")
+		},
+	}, ms.WithWorkers(4))
 
 	app.Run()
 	defer app.CleanUp()
 }
 
-func mapToStruct[T any](data interface{}, result T) error {
-	// Assert the data is a map[string]interface{}
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		fmt.Println("Error marshaling data:", err)
-		return err
-	}
-
-	err = json.Unmarshal(jsonData, &result)
-	if err != nil {
-		fmt.Println("Error unmarshaling data:", err)
-		return err
-	}
-	return nil
+type Register struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+	Username string `json:"username" validate:"required"`
 }
 
-type Register struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Username string `json:"username"`
+// Invitation is the service.send_mail payload, the consumer issues the token it mails.
+type Invitation struct {
+	UserID string `json:"user_id" validate:"required"`
 }
 
 type InComing[T any] struct {
@@ -232,70 +325,3 @@ type InComing[T any] struct {
 	} `json:"header"`
 	Body T `json:"body"`
 }
-
-type Handler struct {
-	*store.Storer
-}
-
-func (s Handler) GetUserByPublicId(c ms.IContext) error {
-	initInvoke := ms.GenerateXTid("profile")
-	cmd := "get_user_by_id"
-
-	detailLog, summaryLog := c.CommonLog(initInvoke, cmd, "anonymous")
-	summaryLog.AddSuccessBlock(constants.CLIENT, cmd, "200", "success")
-
-	var getUser store.User
-
-	publicId := c.Param("publicId")
-	if utils.IsEmail(publicId) {
-		user, err := s.Users.GetByEmail(context.Background(), publicId, detailLog, summaryLog)
-		if err != nil {
-			return c.Response(500, err)
-		}
-		getUser = *user
-	} else {
-		user, err := s.Users.GetByID(context.Background(), publicId, detailLog, summaryLog)
-		if err != nil {
-			detailLog.AddOutputResponse(constants.CLIENT, cmd, initInvoke, nil, err)
-			return c.Response(500, err)
-		}
-		getUser = *user
-	}
-
-	// var optionAttributes []http_service.RequestAttributes
-	// for i := 1; i <= 1; i++ {
-	// 	optionAttribute := http_service.RequestAttributes{
-	// 		Command: "get_x",
-	// 		Service: "node",
-	// 		Invoke:  "get_all_users",
-	// 		Method:  http.MethodGet,
-	// 		URL:     "http://localhost:3000/x/{id}",
-	// 		Headers: map[string]string{
-	// 			"Content-Type": "application/json",
-	// 		},
-	// 		Params: map[string]string{
-	// 			"id": fmt.Sprintf("%d", i),
-	// 		},
-	// 		Timeout: 10,
-	// 	}
-	// 	optionAttributes = append(optionAttributes, optionAttribute)
-	// }
-
-	// 	_, err := http_service.RequestHttp(optionAttributes, detailLog, summaryLog)
-
-	resp := Profile{
-		ID:           getUser.ID,
-		Href:         "http://localhost:8080/users/" + getUser.ID,
-		Email:        getUser.Email,
-		UserName:     getUser.Username,
-		FirstName:    getUser.FirstName,
-		LastName:     getUser.LastName,
-		DateOfBirth:  getUser.DateOfBirth,
-		PhoneNumber:  getUser.PhoneNumber,
-		Gender:       getUser.Gender,
-		DisplayName:  getUser.DisplayName,
-		ProfileImage: getUser.ProfileImage,
-	}
-
-	return c.Response(200, resp)
-}
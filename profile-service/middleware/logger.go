@@ -10,8 +10,11 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/sing3demons/profile-service/constants"
+	"github.com/sing3demons/profile-service/tracing"
+	"github.com/sing3demons/profile-service/utils"
 )
 
+// HandlerResponse is the envelope of every response written by ms.HTTPContext.Response.
 type HandlerResponse struct {
 	ResultCode       string      `json:"resultCode"`
 	ResultDesc       string      `json:"resultDesc"`
@@ -49,20 +52,24 @@ func (crw *customResponseWriter) Write(data []byte) (int, error) {
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var ctx context.Context = r.Context()
-		var invokeId string
-
-		if r.Header.Get(string(constants.Session)) == "" {
-			uuidV7, err := uuid.NewV7()
-			if err != nil {
-				invokeId = uuid.New().String()
-			} else {
-				invokeId = uuidV7.String()
-			}
-		}
-		r = r.WithContext(context.WithValue(r.Context(), constants.Session, invokeId))
 
-		traceID := uuid.New().String()
-		spanID := uuid.New().String()
+		// continue the caller session, the legacy `session` header is still accepted
+		invokeId := r.Header.Get(constants.SessionHeader)
+		if invokeId == "" {
+			invokeId = r.Header.Get(string(constants.Session))
+		}
+		if invokeId == "" {
+			invokeId = utils.NewSession()
+		}
+		r = r.WithContext(utils.ContextWithSession(r.Context(), invokeId))
+		w.Header().Set(constants.SessionHeader, invokeId)
+
+		// use the span opened by the tracing middleware, random IDs when tracing is not installed
+		traceID, spanID := tracing.IDs(r.Context())
+		if traceID == "" {
+			traceID = uuid.New().String()
+			spanID = uuid.New().String()
+		}
 
 		// Add trace_id and span_id to the request context
 		ctx = context.WithValue(r.Context(), constants.TraceIDKey, traceID)
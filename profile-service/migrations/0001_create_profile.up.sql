@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS Profile (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	email VARCHAR(255) UNIQUE,
+	username VARCHAR(255) UNIQUE,
+	password VARCHAR(255),
+	first_name VARCHAR(100),
+	last_name VARCHAR(100),
+	date_of_birth DATE,
+	phone_number VARCHAR(15),
+	gender VARCHAR(50),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	created_by VARCHAR(100),
+	updated_by VARCHAR(100),
+	display_name VARCHAR(255),
+	profile_image TEXT
+);
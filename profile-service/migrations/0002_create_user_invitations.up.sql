@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_invitations (
+	token VARCHAR(255) NOT NULL,
+	user_id UUID NOT NULL REFERENCES Profile(id) ON DELETE CASCADE,
+	expiry TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, token)
+);
@@ -0,0 +1 @@
+ALTER TABLE Profile DROP COLUMN IF EXISTS is_active;
@@ -0,0 +1 @@
+ALTER TABLE Profile ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+package migrations
+
+import "embed"
+
+// FS holds the versioned SQL migrations applied by ms.Migrator.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -6,51 +6,106 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sing3demons/profile-service/logger"
 	"github.com/sing3demons/profile-service/middleware"
 
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	_ "github.com/go-sql-driver/mysql" // MySQL driver
 	_ "github.com/lib/pq"              // PostgreSQL driver
 )
 
 type application struct {
-	config Config
-	logger *zap.Logger
-	router *mux.Router
-	conn   *sql.DB
+	config  Config
+	logger  *zap.Logger
+	router  *mux.Router
+	conn    *sql.DB
+	outbox  *outboxRelay
+	metrics *metrics
+	logSink *kafkaLogSink
+
+	logFiles []*logFile
+	hangup   chan os.Signal
+
+	logPolicy *logger.Policy
+
+	tracerProvider *sdktrace.TracerProvider
+
+	producerOnce sync.Once
+	producer     *Producer
+	producersMu  sync.Mutex
+	producers    []*Producer
+
+	consumers     sync.WaitGroup
+	stopConsumers chan struct{}
+	stopOnce      sync.Once
+
+	healthMu     sync.Mutex
+	healthChecks []namedHealthCheck
+	shuttingDown atomic.Bool
+
+	errorsMu      sync.Mutex
+	errorMappings []errorMapping
 }
 
 type KafkaConfig struct {
 	Brokers string
 	GroupID string
 	TimeOut int
+	// ClientID identifies the service in the broker logs and quotas.
+	ClientID string
+	SASL     KafkaSASL
+	TLS      KafkaTLS
+	// Retry holds the retry and dead-letter policy of each consumed topic.
+	Retry map[string]RetryPolicy
+	// ManualCommit commits the offsets in batches from the consumer, instead of librdkafka
+	// auto commit in the background. Either way an offset is stored once its message is
+	// done with; a failed message of a topic without retry policy is redelivered in manual
+	// mode and dropped, logged with its offset, in auto mode.
+	ManualCommit bool
+	// CommitInterval is the maximum delay between two commits in manual mode, default 1s.
+	CommitInterval time.Duration
+	// CommitBatchSize commits as soon as this many offsets are stored in manual mode, default 100.
+	CommitBatchSize int
 }
 type Config struct {
-	Addr       string
-	Db         DbConfig
-	Env        string
-	Name       string
-	RedisCfg   RedisConfig
-	KafkaCfg   KafkaConfig
-	LogConfig  LogConfig
-	MailServer MailServer
+	Addr string
+	// ShutdownTimeout bounds both the HTTP server shutdown and the wait for in-flight
+	// consumer handlers, default 30s. DrainDelay keeps serving with /readyz DOWN this long
+	// after SIGINT or SIGTERM, so load balancers stop routing to the service before its
+	// listener closes, default none.
+	ShutdownTimeout time.Duration
+	DrainDelay      time.Duration
+	Db              DbConfig `env:"DB"`
+	Env             string
+	Name            string      `env:"SERVICE_NAME"`
+	RedisCfg        RedisConfig `env:"REDIS"`
+	KafkaCfg        KafkaConfig `env:"KAFKA"`
+	LogConfig       LogConfig   `env:"LOG"`
+	MailServer      MailServer  `env:"MAIL"`
+	Tracing         TracingConfig
 }
 
 type RedisConfig struct {
 	Addr    string
-	Pw      string
+	Pw      string `env:"PASSWORD" secret:"true"`
 	Db      int
 	Enabled bool
 }
@@ -61,6 +116,8 @@ type SummaryLogConfig struct {
 	LogFile    bool   `json:"logFile"`
 	LogConsole bool   `json:"logConsole"`
 	LogSummary *zap.Logger
+	// Rotation of the files of Name, by default 500 MB, 3 compressed backups kept a day.
+	Rotation LogRotation `json:"rotation"`
 }
 
 type DetailLogConfig struct {
@@ -69,12 +126,24 @@ type DetailLogConfig struct {
 	LogFile    bool   `json:"logFile"`
 	LogConsole bool   `json:"logConsole"`
 	LogDetail  *zap.Logger
+	// RawDataLimit truncates the raw data longer than this many bytes, no limit when zero.
+	RawDataLimit int `json:"rawDataLimit"`
+	// Sampling keeps a share of the successful detail logs per scenario, failed requests
+	// are always logged. RawData, RawDataLimit and Sampling can be changed at /admin/logs.
+	Sampling []logger.SamplingRule `json:"sampling"`
+	// Rotation of the files of Name, by default 500 MB, 3 compressed backups kept a day.
+	Rotation LogRotation `json:"rotation"`
 }
 
 type AppLog struct {
 	Name    string `json:"name"`
 	LogApp  *zap.Logger
 	LogFile bool `json:"logFile"`
+	// Level is debug, info (default), warn or error, it can be changed at /admin/logs.
+	Level       string `json:"level"`
+	AtomicLevel zap.AtomicLevel
+	// Rotation of the files of Name, by default 10 MB, 3 backups kept 7 days.
+	Rotation LogRotation `json:"rotation"`
 }
 
 type LogConfig struct {
@@ -83,14 +152,24 @@ type LogConfig struct {
 	AppLog      AppLog           `json:"appLog"`
 	Detail      DetailLogConfig  `json:"detail"`
 	Summary     SummaryLogConfig `json:"summary"`
+	// Masking hides more fields of the detail and summary logs, the credentials always are.
+	Masking []logger.MaskRule `json:"masking"`
+	// Kafka ships the detail and summary logs to logstash.
+	Kafka KafkaLogConfig `json:"kafka"`
+	// AdminToken enables /admin/logs for the requests bearing it.
+	AdminToken string `secret:"true"`
 }
 
 type DbConfig struct {
-	Addr         string
+	Addr         string `secret:"dsn"`
 	MaxOpenConns int
 	MaxIdleConns int
 	MaxIdleTime  string
 	Driver       string
+	// Migrations holds the versioned `<version>_<name>.(up|down).sql` files, usually an embed.FS.
+	Migrations fs.FS
+	// AutoMigrate applies pending migrations when ConnDatabase is called.
+	AutoMigrate bool
 }
 
 type IMicroservice interface {
@@ -104,10 +183,19 @@ type IMicroservice interface {
 
 	Log(tag string, msg string)
 
-	Consume(topic string, h ServiceHandleFunc) error
+	Consume(topic string, h ServiceHandleFunc, opts ...ConsumeOption) error
+	ConsumeBatch(topic string, h BatchHandleFunc, opts ...ConsumeOption) error
+	ConsumeTopics(topics []string, h ServiceHandleFunc, opts ...ConsumeOption) error
+	ConsumeRoutes(routes map[string]ServiceHandleFunc, opts ...ConsumeOption) error
+	ReplayDeadLetters(topic string, limit int) (int, error)
 	NewProducer() *Producer
+	StartOutboxRelay(producer *Producer, cfg OutboxConfig)
 
-	ConnDatabase(migrate ...string) *sql.DB
+	ConnDatabase() *sql.DB
+	Migrate(args ...string) error
+
+	AddHealthCheck(name string, check HealthCheck)
+	MapError(target error, err *AppError)
 }
 
 func ensureLogDirExists(path string) error {
@@ -120,46 +208,62 @@ func ensureLogDirExists(path string) error {
 	return nil
 }
 
-func promHandler() http.Handler {
-	prometheus.Register(totalRequests)
-	prometheus.Register(responseStatus)
-	prometheus.Register(httpDuration)
-	reg := prometheus.NewRegistry()
-	promHandler := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
-	return promHandler
-}
-
 func NewApplication(cfg Config) IMicroservice {
+	metrics := NewMetrics(cfg.Name)
+
 	r := mux.NewRouter()
-	r.Handle("/metrics", promHandler())
+	r.Handle("/metrics", metrics.handler())
 	r.Use(Recovery)
+	r.Use(metrics.middleware)
+	r.Use(tracingMiddleware)
 	r.Use(middleware.Logger)
 
-	// r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-	// 	w.WriteHeader(http.StatusOK)
-	// 	w.Write([]byte("OK"))
-	// })
-
-	setupLogging(&cfg)
+	logFiles := setupLogging(&cfg)
 
 	if cfg.LogConfig.Namespace == "" {
 		cfg.LogConfig.Namespace = "default"
 	}
 
-	return &application{
-		config: cfg,
-		logger: cfg.LogConfig.AppLog.LogApp,
-		router: r,
+	app := &application{
+		config:        cfg,
+		logger:        cfg.LogConfig.AppLog.LogApp,
+		router:        r,
+		metrics:       metrics,
+		logFiles:      logFiles,
+		stopConsumers: make(chan struct{}),
+	}
+	if len(logFiles) > 0 {
+		app.reopenLogsOnHangup()
 	}
+	app.setupTracing()
+	app.logPolicy = logger.NewPolicy(cfg.LogConfig.Detail.policySettings())
+	if cfg.LogConfig.AdminToken != "" {
+		r.Handle("/admin/logs", app.adminOnly(http.HandlerFunc(app.logSettingsHandler))).Methods(http.MethodGet, http.MethodPut)
+	}
+	if cfg.LogConfig.Kafka.Enabled {
+		app.logSink = newKafkaLogSink(cfg.LogConfig.Kafka, cfg.Name, metrics, app.publishLogs)
+	}
+
+	r.HandleFunc("/healthz", app.livenessHandler).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", app.readinessHandler).Methods(http.MethodGet)
+
+	return app
 }
 
-func setupLogging(cfg *Config) {
-	setupAppLog(cfg)
-	setupSummaryLog(cfg)
-	setupDetailLog(cfg)
+// setupLogging creates the loggers of cfg and returns the log files they write.
+func setupLogging(cfg *Config) []*logFile {
+	var files []*logFile
+	for _, f := range []*logFile{setupAppLog(cfg), setupSummaryLog(cfg), setupDetailLog(cfg)} {
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	return files
 }
 
-func setupAppLog(cfg *Config) {
+func setupAppLog(cfg *Config) *logFile {
+	var file *logFile
+	var w io.Writer
 	if cfg.LogConfig.AppLog.LogFile {
 		if cfg.LogConfig.AppLog.Name == "" {
 			cfg.LogConfig.AppLog.Name = "./logs/app"
@@ -168,34 +272,71 @@ func setupAppLog(cfg *Config) {
 			log.Fatal(err)
 		}
 
+		file = newLogFile(cfg.LogConfig.AppLog.Name, cfg.Name, cfg.LogConfig.AppLog.Rotation.orDefault(defaultAppLogRotation))
+		w = file
+	}
+	level, err := zapcore.ParseLevel(cfg.LogConfig.AppLog.Level)
+	if err != nil {
+		level = zapcore.InfoLevel
 	}
-	cfg.LogConfig.AppLog.LogApp = NewLogger(cfg.LogConfig.AppLog)
+	cfg.LogConfig.AppLog.AtomicLevel = zap.NewAtomicLevelAt(level)
+	cfg.LogConfig.AppLog.LogApp = NewLogger(cfg.LogConfig.AppLog, w)
+	return file
 }
 
-func setupSummaryLog(cfg *Config) {
-	if cfg.LogConfig.Summary.LogFile {
-		if cfg.LogConfig.Summary.Name == "" {
-			cfg.LogConfig.Summary.Name = "./logs/summary"
-		}
-		if err := ensureLogDirExists(cfg.LogConfig.Summary.Name); err != nil {
-			log.Fatal(err)
-		}
+func setupSummaryLog(cfg *Config) *logFile {
+	if !cfg.LogConfig.Summary.LogFile {
+		return nil
+	}
+	if cfg.LogConfig.Summary.Name == "" {
+		cfg.LogConfig.Summary.Name = "./logs/summary"
+	}
+	if err := ensureLogDirExists(cfg.LogConfig.Summary.Name); err != nil {
+		log.Fatal(err)
+	}
 
-		cfg.LogConfig.Summary.LogSummary = NewLogFile(cfg.LogConfig.Summary.Name)
+	file := newLogFile(cfg.LogConfig.Summary.Name, cfg.Name, cfg.LogConfig.Summary.Rotation.orDefault(defaultLogRotation))
+	cfg.LogConfig.Summary.LogSummary = NewLogFile(file)
+	return file
+}
+
+func setupDetailLog(cfg *Config) *logFile {
+	if !cfg.LogConfig.Detail.LogFile {
+		return nil
+	}
+	if cfg.LogConfig.Detail.Name == "" {
+		cfg.LogConfig.Detail.Name = "./logs/detail"
 	}
+	if err := ensureLogDirExists(cfg.LogConfig.Detail.Name); err != nil {
+		log.Fatal(err)
+	}
+
+	file := newLogFile(cfg.LogConfig.Detail.Name, cfg.Name, cfg.LogConfig.Detail.Rotation.orDefault(defaultLogRotation))
+	cfg.LogConfig.Detail.LogDetail = NewLogFile(file)
+	return file
 }
 
-func setupDetailLog(cfg *Config) {
-	if cfg.LogConfig.Detail.LogFile {
-		if cfg.LogConfig.Detail.Name == "" {
-			cfg.LogConfig.Detail.Name = "./logs/detail"
-		}
-		if err := ensureLogDirExists(cfg.LogConfig.Detail.Name); err != nil {
-			log.Fatal(err)
+// reopenLogsOnHangup reopens the log files on SIGHUP, e.g. after logrotate moved them, until
+// CleanUp stops it.
+func (app *application) reopenLogsOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	app.hangup = hangup
+	go func() {
+		for range hangup {
+			app.reopenLogs()
 		}
+	}()
+}
 
-		cfg.LogConfig.Detail.LogDetail = NewLogFile(cfg.LogConfig.Detail.Name)
+func (app *application) reopenLogs() {
+	for _, f := range app.logFiles {
+		// lumberjack opens the file again on the next write
+		if err := f.Close(); err != nil {
+			app.logger.Error("close log file", zap.String("file", f.Filename), zap.Error(err))
+		}
 	}
+	app.logger.Info("log files reopened")
 }
 
 func Recovery(next http.Handler) http.Handler {
@@ -206,8 +347,9 @@ func Recovery(next http.Handler) http.Handler {
 			if err != nil {
 				fmt.Println(err) // May be log this error? Send to sentry?
 
-				jsonBody, _ := json.Marshal(map[string]string{
-					"error": "There was an internal server error",
+				jsonBody, _ := json.Marshal(middleware.HandlerResponse{
+					ResultCode: "500",
+					ResultDesc: "There was an internal server error",
 				})
 
 				w.Header().Set("Content-Type", "application/json")
@@ -222,7 +364,26 @@ func Recovery(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) ConnDatabase(migrate ...string) *sql.DB {
+func (app *application) ConnDatabase() *sql.DB {
+	app.openDatabase()
+
+	if app.config.Db.AutoMigrate && app.config.Db.Migrations != nil {
+		migrator, err := app.newMigrator()
+		if err != nil {
+			app.logger.Fatal("Error loading migrations", zap.Error(err))
+		}
+
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			app.logger.Fatal("Error applying migrations", zap.Error(err))
+		}
+		app.Log("Migration", fmt.Sprintf("%d migration(s) applied", len(applied)))
+	}
+
+	return app.conn
+}
+
+func (app *application) openDatabase() *sql.DB {
 	db, err := sql.Open(app.config.Db.Driver, app.config.Db.Addr)
 	if err != nil {
 		log.Fatal(err)
@@ -250,20 +411,79 @@ func (app *application) ConnDatabase(migrate ...string) *sql.DB {
 	app.conn = db
 	app.Log("Database", "Database connection established")
 
-	if len(migrate) > 0 {
-		// init create table
-		for _, m := range migrate {
-			_, err := db.Exec(m)
-			if err != nil {
-				app.logger.Error("Error create table", zap.Error(err))
-				os.Exit(1)
-			}
-		}
+	if err := app.metrics.registerDB(db); err != nil {
+		app.logger.Warn("database metrics not registered", zap.Error(err))
 	}
 
 	return app.conn
 }
 
+func (app *application) newMigrator() (*Migrator, error) {
+	if app.config.Db.Migrations == nil {
+		return nil, ErrNoMigrations
+	}
+	return NewMigrator(app.conn, app.config.Db.Driver, app.config.Db.Migrations, app.Log)
+}
+
+// Migrate runs the `migrate up|down [steps]|status` command against the configured database.
+func (app *application) Migrate(args ...string) error {
+	if app.conn == nil {
+		app.openDatabase()
+	}
+
+	migrator, err := app.newMigrator()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if args[1] == "all" {
+				steps = math.MaxInt
+			} else if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied at " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q (expected up, down or status)", ErrUnknownMigration, command)
+	}
+}
+
 func (app *application) Run() error {
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%s", app.config.Addr),
@@ -281,12 +501,8 @@ func (app *application) Run() error {
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		s := <-quit
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
 		app.logger.Info("shutting down server", zap.String("signal", s.String()))
-
-		shutdown <- srv.Shutdown(ctx)
+		shutdown <- app.shutdown(srv)
 	}()
 
 	hostName, _ := os.Hostname()
@@ -331,11 +547,67 @@ func (app *application) Run() error {
 	return nil
 }
 
+// NewProducer returns a producer owned by the application, it is flushed and closed by CleanUp.
 func (app *application) NewProducer() *Producer {
 	producer := NewProducer(app.config.KafkaCfg.Brokers, app)
+
+	app.producersMu.Lock()
+	app.producers = append(app.producers, producer)
+	app.producersMu.Unlock()
+
 	return producer
 }
 
+// shutdown answers /readyz DOWN for DrainDelay while still serving, then shuts srv down
+// within ShutdownTimeout.
+func (app *application) shutdown(srv *http.Server) error {
+	app.shuttingDown.Store(true)
+	time.Sleep(app.config.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.shutdownTimeout())
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+func (app *application) shutdownTimeout() time.Duration {
+	if app.config.ShutdownTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return app.config.ShutdownTimeout
+}
+
+// logConfig copies the application log settings into the logger package configuration.
+func (m *application) logConfig() logger.LogConfig {
+	conf := logger.LogConfig{}
+	conf.ProjectName = m.config.LogConfig.ProjectName
+	conf.Namespace = m.config.LogConfig.Namespace
+
+	conf.Summary.RawData = m.config.LogConfig.Summary.RawData
+	conf.Summary.LogFile = m.config.LogConfig.Summary.LogFile
+	conf.Summary.LogConsole = m.config.LogConfig.Summary.LogConsole
+	conf.Summary.LogSummary = m.config.LogConfig.Summary.LogSummary
+
+	conf.Detail.RawData = m.config.LogConfig.Detail.RawData
+	conf.Detail.LogFile = m.config.LogConfig.Detail.LogFile
+	conf.Detail.LogConsole = m.config.LogConfig.Detail.LogConsole
+	conf.Detail.LogDetail = m.config.LogConfig.Detail.LogDetail
+
+	conf.Masking = m.config.LogConfig.Masking
+	conf.Policy = m.logPolicy
+	if m.logSink != nil {
+		conf.Sinks = []logger.Sink{m.logSink}
+	}
+	return conf
+}
+
+// sharedProducer returns the producer used by the framework itself (retries, dead letters).
+func (app *application) sharedProducer() *Producer {
+	app.producerOnce.Do(func() {
+		app.producer = NewProducer(app.config.KafkaCfg.Brokers, app)
+	})
+	return app.producer
+}
+
 func (m *application) Log(tag string, msg string) {
 	m.logger.Info(fmt.Sprintf("[%s]: %s", tag, msg))
 }
@@ -345,42 +617,104 @@ func (m *application) Use(middleware func(http.Handler) http.Handler) {
 }
 
 func (m *application) GET(path string, h ServiceHandleFunc) {
-	m.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		h(NewHTTPContext(w, r, m))
-	}).Methods(http.MethodGet)
+	m.router.HandleFunc(path, m.httpHandler(h)).Methods(http.MethodGet)
 }
 
 func (m *application) POST(path string, h ServiceHandleFunc) {
-	m.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("POST")
-		h(NewHTTPContext(w, r, m))
-	}).Methods(http.MethodPost)
+	m.router.HandleFunc(path, m.httpHandler(h)).Methods(http.MethodPost)
 }
 
 func (m *application) PUT(path string, h ServiceHandleFunc) {
-	m.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		h(NewHTTPContext(w, r, m))
-	}).Methods(http.MethodPut)
+	m.router.HandleFunc(path, m.httpHandler(h)).Methods(http.MethodPut)
 }
 
 func (m *application) DELETE(path string, h ServiceHandleFunc) {
-	m.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		h(NewHTTPContext(w, r, m))
-	}).Methods(http.MethodDelete)
+	m.router.HandleFunc(path, m.httpHandler(h)).Methods(http.MethodDelete)
 }
 
 func (m *application) PATCH(path string, h ServiceHandleFunc) {
-	m.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		h(NewHTTPContext(w, r, m))
-	}).Methods(http.MethodPatch)
+	m.router.HandleFunc(path, m.httpHandler(h)).Methods(http.MethodPatch)
+}
+
+// httpHandler runs h and treats a returned error as a failed request: it is counted and,
+// when h did not respond yet, answered like Response(500, err) which also ends the logs.
+// A successful handler that did not respond is answered 204 once its deferred messages are
+// sent, messages deferred after JSON are sent on return and the logs they open are ended.
+func (m *application) httpHandler(h ServiceHandleFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := newHTTPContext(w, r, m)
+		err := h(c)
+		if err == nil {
+			// a handler returning nil succeeded, its deferred messages are due
+			if !c.written {
+				err = c.Response(http.StatusNoContent, nil)
+			} else {
+				// the response ended the request logs, the sends open logs of their own
+				var code int
+				var data any
+				code, data, err = c.deferred.settle(http.StatusOK, nil, c.sendKafkaMessage)
+				_, _, resultCode, resultDesc := m.envelope(code, data)
+				c.endLogs(nil, resultCode, resultDesc)
+			}
+			if err == nil {
+				return
+			}
+		}
+
+		m.metrics.httpErrors.WithLabelValues(r.Method, routeTemplate(r)).Inc()
+		if !c.written {
+			c.Response(http.StatusInternalServerError, err)
+		}
+	}
 }
 
+// CleanUp releases the application resources in dependency order: consumers stop polling and
+// drain their in-flight handlers (committing offsets), the outbox relay stops, the buffered logs
+// and the producers are flushed, then the database is closed and the loggers synced.
 func (m *application) CleanUp() {
+	m.stopOnce.Do(func() { close(m.stopConsumers) })
+	if m.waitConsumers(m.shutdownTimeout()) {
+		m.logger.Info("consumers stopped")
+	} else {
+		m.logger.Warn("consumers did not stop in time, in-flight messages will be redelivered",
+			zap.Duration("timeout", m.shutdownTimeout()))
+	}
+
+	if m.outbox != nil {
+		m.outbox.Stop()
+		m.logger.Info("outbox relay stopped")
+	}
+
+	if m.logSink != nil {
+		m.logSink.Close()
+	}
+
+	m.producersMu.Lock()
+	producers := m.producers
+	m.producers = nil
+	m.producersMu.Unlock()
+	for _, producer := range producers {
+		producer.Close()
+	}
+
+	if m.producer != nil {
+		m.producer.Close()
+	}
+
 	if m.conn != nil {
 		m.conn.Close()
 		m.logger.Info("database connection closed")
 	}
 
+	if m.tracerProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := m.tracerProvider.Shutdown(ctx); err != nil {
+			m.logger.Error("tracer provider shutdown", zap.Error(err))
+		}
+		cancel()
+		m.tracerProvider = nil
+	}
+
 	if m.config.LogConfig.Summary.LogSummary != nil {
 		m.config.LogConfig.Summary.LogSummary.Sync()
 	}
@@ -392,4 +726,29 @@ func (m *application) CleanUp() {
 	if m.logger != nil {
 		m.logger.Sync()
 	}
+
+	if m.hangup != nil {
+		signal.Stop(m.hangup)
+		close(m.hangup)
+		m.hangup = nil
+	}
+	for _, f := range m.logFiles {
+		f.shutdown()
+	}
+}
+
+// waitConsumers waits for every consumer loop to return and reports false on timeout.
+func (m *application) waitConsumers(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		m.consumers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
@@ -2,6 +2,7 @@ package ms
 
 import (
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -51,3 +52,37 @@ func TestNewApplication(t *testing.T) {
 	assert.NotNil(t, cfg.LogConfig.AppLog.LogApp, app.(*application).logger)
 	assert.NotNil(t, app.(*application).router)
 }
+
+func TestCleanUpStopsConsumers(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+
+	stopped := false
+	app.consumers.Add(1)
+	go func() {
+		defer app.consumers.Done()
+		<-app.stopConsumers
+		stopped = true
+	}()
+
+	app.CleanUp()
+	assert.True(t, stopped)
+
+	// a second call must not panic on the closed stop channel
+	app.CleanUp()
+}
+
+func TestCleanUpConsumerTimeout(t *testing.T) {
+	c := cfg
+	c.ShutdownTimeout = 10 * time.Millisecond
+	app := NewApplication(c).(*application)
+
+	release := make(chan struct{})
+	defer close(release)
+	app.consumers.Add(1)
+	go func() {
+		defer app.consumers.Done()
+		<-release
+	}()
+
+	assert.False(t, app.waitConsumers(app.shutdownTimeout()))
+}
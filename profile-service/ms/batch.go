@@ -0,0 +1,206 @@
+package ms
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/sing3demons/profile-service/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBatchSize   = 100
+	defaultBatchWindow = time.Second
+)
+
+// BatchHandleFunc handles the messages of a batch in their consumed order, one context per
+// message. An error fails the whole batch.
+type BatchHandleFunc func(messages []IContext) error
+
+// ConsumeBatch registers h for topic, it receives up to WithBatchSize messages or what arrived
+// within WithBatchWindow of the first one. The messages of a retry topic are handled one by one.
+func (ms *application) ConsumeBatch(topic string, h BatchHandleFunc, opts ...ConsumeOption) error {
+	ctx := consumerContext{
+		topic:        topic,
+		batchSize:    defaultBatchSize,
+		batchWindow:  defaultBatchWindow,
+		batchHandler: h,
+	}
+	for _, opt := range opts {
+		opt(&ctx)
+	}
+	if ctx.batchSize <= 0 {
+		ctx.batchSize = defaultBatchSize
+	}
+	if ctx.batchWindow <= 0 {
+		ctx.batchWindow = defaultBatchWindow
+	}
+
+	return ms.register(ctx, func(c IContext) error {
+		return h([]IContext{c})
+	})
+}
+
+// consumeBatches fills and handles batches until the consumers are stopped. A batch that is
+// not full when the consumers stop is left for redelivery.
+func (ms *application) consumeBatches(ctx consumerContext, c *kafka.Consumer, committer *offsetCommitter) {
+	var batch []*kafka.Message
+	var deadline time.Time
+
+	for {
+		select {
+		case <-ms.stopConsumers:
+			committer.commit()
+			return
+		default:
+		}
+
+		readTimeout := consumerPollTimeout
+		if len(batch) > 0 {
+			readTimeout = min(readTimeout, time.Until(deadline))
+		}
+
+		if readTimeout > 0 {
+			ctx.subscription.refresh(c)
+			msg, err := c.ReadMessage(readTimeout)
+			if err != nil {
+				ms.handleKafkaError(ctx, err)
+			} else {
+				ms.observeConsumed(c, msg)
+				if len(batch) == 0 {
+					deadline = time.Now().Add(ctx.batchWindow)
+				}
+				batch = append(batch, msg)
+			}
+		}
+
+		if len(batch) >= ctx.batchSize || (len(batch) > 0 && !time.Now().Before(deadline)) {
+			ms.processBatch(ctx, c, committer, batch)
+			batch = nil
+		}
+		committer.maybeCommit()
+	}
+}
+
+func (ms *application) processBatch(ctx consumerContext, c *kafka.Consumer, committer *offsetCommitter, batch []*kafka.Message) {
+	messages := make([]kafkaMessage, len(batch))
+	for i, msg := range batch {
+		messages[i] = newKafkaMessage(msg)
+	}
+
+	start := time.Now()
+	done := ms.handleBatch(messages, ctx.batchHandler)
+	ms.metrics.consumerDuration.WithLabelValues(ctx.topic).Observe(time.Since(start).Seconds())
+
+	stored, rewind := batchOffsets(batch, done)
+	if len(stored) > 0 {
+		committer.storeOffsets(stored)
+	}
+	if len(rewind) == 0 {
+		return
+	}
+
+	// rewind the partitions so the messages not done with are delivered again
+	for _, tp := range rewind {
+		if err := c.Seek(tp, 0); err != nil {
+			ms.Log("Consumer", fmt.Sprintf("seek %s: %s", tp, err.Error()))
+		}
+	}
+	ms.sleep(context.Background(), redeliveryBackoff)
+}
+
+// batchOffsets splits batch, in consumed order, after its first done messages: it returns the
+// offset to store of the partitions with messages done, and the first message not done of the
+// other partitions to rewind to.
+func batchOffsets(batch []*kafka.Message, done int) (stored, rewind []kafka.TopicPartition) {
+	storedAt := map[partitionKey]int{}
+	pending := map[partitionKey]bool{}
+	for i, msg := range batch {
+		key := partitionKey{*msg.TopicPartition.Topic, msg.TopicPartition.Partition}
+		tp := msg.TopicPartition
+		if i >= done {
+			if !pending[key] {
+				pending[key] = true
+				rewind = append(rewind, tp)
+			}
+			continue
+		}
+
+		tp.Offset++
+		if j, ok := storedAt[key]; ok {
+			stored[j] = tp
+		} else {
+			storedAt[key] = len(stored)
+			stored = append(stored, tp)
+		}
+	}
+	return stored, rewind
+}
+
+// handleBatch is handleMessage for a batch: the immediate retries run the whole batch again,
+// then every message is forwarded to the next retry topic. It returns how many messages, from
+// the first, are done with; the forwarded ones stay done when forwarding the next one fails.
+func (ms *application) handleBatch(messages []kafkaMessage, h BatchHandleFunc) int {
+	topic := messages[0].originalTopic()
+	policy, ok := ms.retryPolicy(topic)
+	if !ok {
+		if err := ms.runBatchHandler(messages, h); err != nil {
+			ms.Log("Consumer", fmt.Sprintf("topic %s: batch of %d: %s", topic, len(messages), err.Error()))
+			if ms.config.KafkaCfg.ManualCommit {
+				return 0
+			}
+			for _, message := range messages {
+				ms.logDropped(message, err)
+			}
+		}
+		return len(messages)
+	}
+
+	var err error
+	for i := 0; i <= policy.MaxRetries; i++ {
+		if i > 0 && policy.Backoff > 0 && !ms.sleep(context.Background(), policy.Backoff) {
+			return 0
+		}
+		if err = ms.runBatchHandler(messages, h); err == nil {
+			return len(messages)
+		}
+	}
+
+	for i, message := range messages {
+		if err := ms.forwardFailedMessage(message, policy, message.attempts()+policy.MaxRetries+1, err); err != nil {
+			ms.logger.Error("forward failed message", zap.String("topic", message.topic), zap.Error(err))
+			return i
+		}
+	}
+	return len(messages)
+}
+
+// runBatchHandler calls h once in a consumer span, every message context is a child of it.
+func (ms *application) runBatchHandler(messages []kafkaMessage, h BatchHandleFunc) error {
+	first := messages[0]
+	attrs := append(kafkaAttributes(first.topic, first.partition, first.offset), semconv.MessagingBatchMessageCount(len(messages)))
+	ctx, span := tracing.Start(context.Background(), first.topic+" process", trace.SpanKindConsumer, attrs...)
+
+	contexts := make([]*ConsumerContext, len(messages))
+	batch := make([]IContext, len(messages))
+	for i, message := range messages {
+		contexts[i] = newConsumerContext(ctx, message, ms)
+		batch[i] = contexts[i]
+	}
+
+	err := h(batch)
+	for _, c := range contexts {
+		if finishErr := c.finish(err); err == nil {
+			err = finishErr
+		}
+	}
+	tracing.End(span, err)
+	if err != nil {
+		ms.metrics.consumerErrors.WithLabelValues(first.topic).Add(float64(len(messages)))
+	}
+	return err
+}
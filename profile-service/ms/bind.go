@@ -0,0 +1,307 @@
+package ms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sing3demons/profile-service/utils"
+)
+
+// FieldError describes one invalid field of a bound request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by Bind when the input cannot be decoded or breaks a
+// `validate` rule. Responding with it answers 400 with the field errors as envelope data:
+//
+//	{"resultCode": "400", "resultDesc": "invalid request", "data": [{"field": "email", "message": "must be a valid email"}]}
+type ValidationError struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Errors))
+	for _, f := range e.Errors {
+		fields = append(fields, f.Field+" "+f.Message)
+	}
+	return e.Message + ": " + strings.Join(fields, ", ")
+}
+
+// bindSource is the input of one request, the same for HTTP and Kafka.
+type bindSource struct {
+	body    []byte
+	query   url.Values
+	params  map[string]string
+	headers http.Header
+}
+
+// bind fills dst, a pointer to a struct, from src and validates it. The body is decoded with
+// the `json` tags, then fields tagged `query:"name"`, `param:"name"` or `header:"name"` are
+// set from the query string, the path parameters and the headers.
+//
+// The `validate` tag holds comma separated rules: required, email, min=n, max=n and
+// oneof=a b c. min and max bound the length of strings and slices and the value of numbers.
+// Except required, rules are only checked on fields that are set: non-zero values and
+// non-nil pointers.
+func bind(dst any, src bindSource) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("bind: destination must be a non-nil pointer to a struct, got %T", dst)
+	}
+
+	var fieldErrors []FieldError
+	if len(bytes.TrimSpace(src.body)) > 0 {
+		if err := json.Unmarshal(src.body, dst); err != nil {
+			fieldErrors = append(fieldErrors, decodeError(err))
+		}
+	}
+
+	bindValues(rv.Elem(), src, &fieldErrors)
+	validateStruct(rv.Elem(), "", &fieldErrors)
+
+	if len(fieldErrors) > 0 {
+		return &ValidationError{Message: "invalid_request", Errors: fieldErrors}
+	}
+	return nil
+}
+
+func decodeError(err error) FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return FieldError{Field: typeErr.Field, Message: "must be " + typeName(typeErr.Type)}
+	}
+	return FieldError{Field: "body", Message: "must be valid JSON: " + err.Error()}
+}
+
+func bindValues(v reflect.Value, src bindSource, fieldErrors *[]FieldError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		var values []string
+		var name string
+		if name = sf.Tag.Get("query"); name != "" {
+			values = src.query[name]
+		} else if name = sf.Tag.Get("param"); name != "" {
+			if value, ok := src.params[name]; ok {
+				values = []string{value}
+			}
+		} else if name = sf.Tag.Get("header"); name != "" {
+			values = src.headers.Values(name)
+		} else if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			bindValues(v.Field(i), src, fieldErrors)
+			continue
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(v.Field(i), values); err != nil {
+			*fieldErrors = append(*fieldErrors, FieldError{Field: name, Message: err.Error()})
+		}
+	}
+}
+
+func setField(fv reflect.Value, values []string) error {
+	switch fv.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(fv.Type().Elem())
+		if err := setField(elem.Elem(), values); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	case reflect.Slice:
+		slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setScalar(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	default:
+		return setScalar(fv, values[0])
+	}
+}
+
+func setScalar(fv reflect.Value, value string) error {
+	invalid := fmt.Errorf("must be %s", typeName(fv.Type()))
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return invalid
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return invalid
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return invalid
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return invalid
+		}
+		fv.SetFloat(n)
+	default:
+		return fmt.Errorf("has unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	default:
+		return "a " + t.Kind().String()
+	}
+}
+
+// fieldName is the name a client knows the field by: its json, query, param or header name.
+func fieldName(sf reflect.StructField) string {
+	if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	for _, tag := range []string{"query", "param", "header"} {
+		if name := sf.Tag.Get(tag); name != "" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func validateStruct(v reflect.Value, prefix string, fieldErrors *[]FieldError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		fv := v.Field(i)
+		name := prefix + fieldName(sf)
+		if rules := sf.Tag.Get("validate"); rules != "" && rules != "-" {
+			for _, rule := range strings.Split(rules, ",") {
+				if msg := checkRule(strings.TrimSpace(rule), fv); msg != "" {
+					*fieldErrors = append(*fieldErrors, FieldError{Field: name, Message: msg})
+					break
+				}
+			}
+		}
+
+		if fv.Kind() == reflect.Pointer && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct {
+			if sf.Anonymous && sf.Tag.Get("json") == "" {
+				validateStruct(fv, prefix, fieldErrors)
+			} else {
+				validateStruct(fv, name+".", fieldErrors)
+			}
+		}
+	}
+}
+
+// checkRule returns the error message of rule for fv, empty when fv satisfies it.
+func checkRule(rule string, fv reflect.Value) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	if name == "required" {
+		if fv.IsZero() {
+			return "is required"
+		}
+		return ""
+	}
+
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			return ""
+		}
+		fv = fv.Elem()
+	} else if fv.IsZero() {
+		return ""
+	}
+
+	switch name {
+	case "email":
+		if fv.Kind() == reflect.String && !utils.IsEmail(fv.String()) {
+			return "must be a valid email"
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Sprintf("has invalid rule %q", rule)
+		}
+		value, unit := measure(fv)
+		if name == "min" && value < limit {
+			return strings.TrimSpace(fmt.Sprintf("must be at least %s %s", arg, unit))
+		}
+		if name == "max" && value > limit {
+			return strings.TrimSpace(fmt.Sprintf("must be at most %s %s", arg, unit))
+		}
+	case "oneof":
+		allowed := strings.Fields(arg)
+		value := fmt.Sprint(fv.Interface())
+		for _, a := range allowed {
+			if a == value {
+				return ""
+			}
+		}
+		return "must be one of: " + strings.Join(allowed, ", ")
+	default:
+		return fmt.Sprintf("has unknown rule %q", rule)
+	}
+	return ""
+}
+
+// measure returns what min and max compare: a length for strings and collections, the value for numbers.
+func measure(fv reflect.Value) (float64, string) {
+	switch fv.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(fv.String())), "characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(fv.Len()), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), ""
+	default:
+		return 0, ""
+	}
+}
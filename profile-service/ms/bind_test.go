@@ -0,0 +1,122 @@
+package ms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+type bindAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type bindRequest struct {
+	ID      string       `param:"id"`
+	Email   string       `json:"email" validate:"required,email"`
+	Name    string       `json:"name" validate:"min=2,max=5"`
+	Gender  string       `json:"gender" validate:"oneof=male female"`
+	Age     *int         `json:"age" validate:"min=0,max=150"`
+	Tags    []string     `query:"tag" validate:"max=2"`
+	Limit   int          `query:"limit"`
+	Tenant  string       `header:"X-Tenant" validate:"required"`
+	Address *bindAddress `json:"address"`
+}
+
+func TestBindHTTP(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	var got bindRequest
+	var bindErr error
+	app.POST("/users/{id}", func(c IContext) error {
+		bindErr = c.Bind(&got)
+		if bindErr != nil {
+			return c.Response(400, bindErr)
+		}
+		return c.Response(200, got)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/users/42?tag=a&tag=b&limit=10", strings.NewReader(`{"email":"a@b.co","name":"bob","age":30,"address":{"city":"BKK"}}`))
+	req.Header.Set("X-Tenant", "acme")
+	app.router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.NoError(t, bindErr)
+	assert.Equal(t, "42", got.ID)
+	assert.Equal(t, "a@b.co", got.Email)
+	assert.Equal(t, []string{"a", "b"}, got.Tags)
+	assert.Equal(t, 10, got.Limit)
+	assert.Equal(t, "acme", got.Tenant)
+	assert.Equal(t, 30, *got.Age)
+	assert.Equal(t, "BKK", got.Address.City)
+
+	got = bindRequest{}
+	req = httptest.NewRequest(http.MethodPost, "/users/42?limit=x", strings.NewReader(`{"email":"nope","name":"b","gender":"x","age":-1,"address":{}}`))
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var body struct {
+		ResultCode string       `json:"resultCode"`
+		Data       []FieldError `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "400", body.ResultCode)
+	assert.ElementsMatch(t, []FieldError{
+		{Field: "limit", Message: "must be an integer"},
+		{Field: "email", Message: "must be a valid email"},
+		{Field: "name", Message: "must be at least 2 characters"},
+		{Field: "gender", Message: "must be one of: male, female"},
+		{Field: "age", Message: "must be at least 0"},
+		{Field: "X-Tenant", Message: "is required"},
+		{Field: "address.city", Message: "is required"},
+	}, body.Data)
+}
+
+func TestBindConsumer(t *testing.T) {
+	headers := []kafka.Header{{Key: "X-Tenant", Value: []byte("acme")}}
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "payload", value: `{"header":{"session":"s"},"body":{"email":"a@b.co","name":"bob"}}`},
+		{name: "raw message", value: `{"email":"a@b.co","name":"bob"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConsumerContext(context.Background(), kafkaMessage{topic: "t", value: tt.value, headers: headers}, nil)
+
+			var got bindRequest
+			assert.NoError(t, c.Bind(&got))
+			assert.Equal(t, "a@b.co", got.Email)
+			assert.Equal(t, "bob", got.Name)
+			assert.Equal(t, "acme", got.Tenant)
+		})
+	}
+
+	c := newConsumerContext(context.Background(), kafkaMessage{topic: "t", value: `{"body":{"email":1}}`}, nil)
+	var got bindRequest
+	err := c.Bind(&got)
+
+	var validationErr *ValidationError
+	if assert.True(t, errors.As(err, &validationErr)) {
+		assert.Contains(t, validationErr.Errors, FieldError{Field: "email", Message: "must be a string"})
+		assert.Contains(t, validationErr.Errors, FieldError{Field: "X-Tenant", Message: "is required"})
+	}
+}
+
+func TestBindInvalidDestination(t *testing.T) {
+	var notStruct string
+	err := bind(&notStruct, bindSource{})
+	assert.Error(t, err)
+
+	var validationErr *ValidationError
+	assert.False(t, errors.As(err, &validationErr))
+}
@@ -0,0 +1,464 @@
+package ms
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the config file when the -config flag is not given.
+const ConfigFileEnv = "CONFIG_FILE"
+
+const redactedValue = "******"
+
+// LoadConfig builds the service Config from defaults, then the YAML or JSON file given with
+// -config or CONFIG_FILE, then the environment and finally the command line flags, each
+// source overriding the previous ones. It returns the arguments left after the flags.
+//
+// Every scalar field has a key: the environment variable KAFKA_SASL_MECHANISM is the flag
+// -kafka.sasl.mechanism and the file entry kafka.sasl.mechanism. Maps, loggers and file
+// systems are only set in code.
+func LoadConfig(defaults Config, args []string) (Config, []string, error) {
+	cfg := defaults
+	fields := configFields()
+
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	file := fs.String("config", "", "YAML or JSON config file, $"+ConfigFileEnv)
+	var flags []func() error
+	for _, f := range fields {
+		usage := "$" + f.env
+		set := func(s string) error {
+			flags = append(flags, func() error { return f.set(&cfg, s) })
+			return nil
+		}
+		if f.typ.Kind() == reflect.Bool {
+			fs.BoolFunc(f.key, usage, set)
+		} else {
+			fs.Func(f.key, usage, set)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return cfg, nil, err
+	}
+
+	var errs []error
+	path := *file
+	if path == "" {
+		path = os.Getenv(ConfigFileEnv)
+	}
+	if path != "" {
+		if err := loadConfigFile(&cfg, path); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(&cfg, value); err != nil {
+				errs = append(errs, fmt.Errorf("$%s: %w", f.env, err))
+			}
+		}
+	}
+
+	for _, set := range flags {
+		if err := set(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return cfg, nil, err
+	}
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+// Validate reports every invalid setting, the service must not start with any.
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if port, err := strconv.Atoi(c.Addr); err != nil || port <= 0 || port > 65535 {
+		invalid("addr", "%q is not a port number", c.Addr)
+	}
+	if c.Name == "" {
+		invalid("service-name", "is required")
+	}
+	if c.ShutdownTimeout < 0 {
+		invalid("shutdown-timeout", "must not be negative")
+	}
+	if c.DrainDelay < 0 {
+		invalid("drain-delay", "must not be negative")
+	}
+
+	if c.Db.Addr != "" && c.Db.Driver == "" {
+		invalid("db.driver", "is required with db.addr")
+	}
+	if c.Db.MaxOpenConns < 0 || c.Db.MaxIdleConns < 0 {
+		invalid("db", "connection pool sizes must not be negative")
+	}
+	if c.Db.MaxIdleTime != "" {
+		if _, err := time.ParseDuration(c.Db.MaxIdleTime); err != nil {
+			invalid("db.max-idle-time", "%q is not a duration", c.Db.MaxIdleTime)
+		}
+	}
+
+	if _, err := c.KafkaCfg.clientConfig(); err != nil {
+		invalid("kafka", "%s", err.Error())
+	}
+	if c.KafkaCfg.CommitInterval < 0 || c.KafkaCfg.CommitBatchSize < 0 {
+		invalid("kafka", "commit interval and batch size must not be negative")
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogConfig.AppLog.Level); err != nil {
+		invalid("log.app-log.level", "%q is not a log level", c.LogConfig.AppLog.Level)
+	}
+	if err := c.LogConfig.Detail.policySettings().Validate(); err != nil {
+		invalid("log.detail", "%s", err.Error())
+	}
+	if err := c.LogConfig.AppLog.Rotation.validate(); err != nil {
+		invalid("log.app-log.rotation", "%s", err.Error())
+	}
+	if err := c.LogConfig.Detail.Rotation.validate(); err != nil {
+		invalid("log.detail.rotation", "%s", err.Error())
+	}
+	if err := c.LogConfig.Summary.Rotation.validate(); err != nil {
+		invalid("log.summary.rotation", "%s", err.Error())
+	}
+	if p := c.LogConfig.Kafka.Policy; p != "" && !strings.EqualFold(p, LogSinkDrop) && !strings.EqualFold(p, LogSinkBlock) {
+		invalid("log.kafka.policy", "%q is neither %s nor %s", p, LogSinkDrop, LogSinkBlock)
+	}
+
+	if c.MailServer.Port < 0 || c.MailServer.Port > 65535 {
+		invalid("mail.port", "%d is not a port number", c.MailServer.Port)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sample-ratio", "must be between 0 and 1")
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns every setting by key with the passwords masked, safe to log.
+func (c Config) Redacted() map[string]any {
+	v := reflect.ValueOf(c)
+	settings := map[string]any{}
+	for _, f := range configFields() {
+		field, ok := f.value(v, false)
+		if !ok {
+			continue
+		}
+		value := field.Interface()
+		if s, isString := value.(string); isString && s != "" {
+			switch f.secret {
+			case "true":
+				value = redactedValue
+			case "dsn":
+				value = redactDSN(s)
+			}
+		}
+		if d, isDuration := value.(time.Duration); isDuration {
+			value = d.String()
+		}
+		settings[f.key] = value
+	}
+	return settings
+}
+
+// String is the redacted config as JSON.
+func (c Config) String() string {
+	b, _ := json.Marshal(c.Redacted())
+	return string(b)
+}
+
+// redactDSN masks the password of a URL or a user:password@host data source name.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+		return dsn
+	}
+	at := strings.LastIndex(dsn, "@")
+	colon := strings.Index(dsn, ":")
+	if at < 0 || colon < 0 || colon > at {
+		return dsn
+	}
+	return dsn[:colon+1] + redactedValue + dsn[at:]
+}
+
+// configField is a scalar setting of Config.
+type configField struct {
+	key    string
+	env    string
+	names  [][]string
+	index  []int
+	typ    reflect.Type
+	secret string
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configFields lists the settings of Config. A field is named by its env tag, else its json
+// tag, else its Go name.
+func configFields() []configField {
+	var fields []configField
+	var walk func(t reflect.Type, env []string, names [][]string, index []int)
+	walk = func(t reflect.Type, env []string, names [][]string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+
+			name := sf.Name
+			alternatives := []string{normalizeKey(sf.Name)}
+			if tag, _, _ := strings.Cut(sf.Tag.Get("json"), ","); tag != "" && tag != "-" {
+				name = tag
+				alternatives = append(alternatives, normalizeKey(tag))
+			}
+			segment := upperSnake(name)
+			if tag := sf.Tag.Get("env"); tag != "" {
+				segment = tag
+				alternatives = append(alternatives, normalizeKey(tag))
+			}
+
+			fieldEnv := append(append([]string{}, env...), segment)
+			fieldNames := append(append([][]string{}, names...), alternatives)
+			fieldIndex := append(append([]int{}, index...), i)
+
+			// the nested settings are the structs of this package, e.g. *Auth
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer && ft.Elem().Kind() == reflect.Struct {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft.PkgPath() == t.PkgPath() {
+				walk(ft, fieldEnv, fieldNames, fieldIndex)
+				continue
+			}
+			if !isScalar(ft) {
+				continue
+			}
+
+			key := make([]string, len(fieldEnv))
+			for i, s := range fieldEnv {
+				key[i] = strings.ReplaceAll(strings.ToLower(s), "_", "-")
+			}
+			fields = append(fields, configField{
+				key:    strings.Join(key, "."),
+				env:    strings.Join(fieldEnv, "_"),
+				names:  fieldNames,
+				index:  fieldIndex,
+				typ:    ft,
+				secret: sf.Tag.Get("secret"),
+			})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), nil, nil, nil)
+	return fields
+}
+
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// value returns the field in v, allocating the nil structs on the way when alloc is set.
+func (f configField) value(v reflect.Value, alloc bool) (reflect.Value, bool) {
+	for _, i := range f.index {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+// set parses s into the field of cfg.
+func (f configField) set(cfg *Config, s string) error {
+	field, _ := f.value(reflect.ValueOf(cfg).Elem(), true)
+
+	s = strings.TrimSpace(s)
+	switch {
+	case f.typ == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration", f.key, s)
+		}
+		field.SetInt(int64(d))
+	case f.typ.Kind() == reflect.String:
+		field.SetString(s)
+	case f.typ.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", f.key, s)
+		}
+		field.SetBool(b)
+	case f.typ.Kind() == reflect.Float32 || f.typ.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", f.key, s)
+		}
+		field.SetFloat(n)
+	case f.typ.Kind() == reflect.Slice:
+		var values []string
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values).Convert(f.typ))
+	case field.CanInt():
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || field.OverflowInt(n) {
+			return fmt.Errorf("%s: %q is not an integer", f.key, s)
+		}
+		field.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || field.OverflowUint(n) {
+			return fmt.Errorf("%s: %q is not a positive integer", f.key, s)
+		}
+		field.SetUint(n)
+	}
+	return nil
+}
+
+// matches reports whether the file path, normalized, names the field.
+func (f configField) matches(path []string) bool {
+	if len(path) != len(f.names) {
+		return false
+	}
+	for i, segment := range path {
+		found := false
+		for _, name := range f.names[i] {
+			if name == segment {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func loadConfigFile(cfg *Config, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	data := map[string]any{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(b, &data)
+	} else {
+		err = yaml.Unmarshal(b, &data)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	fields := configFields()
+	var errs []error
+	var apply func(prefix []string, data map[string]any)
+	apply = func(prefix []string, data map[string]any) {
+		for key, raw := range data {
+			keyPath := append(append([]string{}, prefix...), normalizeKey(key))
+			if nested, ok := raw.(map[string]any); ok {
+				apply(keyPath, nested)
+				continue
+			}
+
+			var field *configField
+			for i := range fields {
+				if fields[i].matches(keyPath) {
+					field = &fields[i]
+					break
+				}
+			}
+			if field == nil {
+				errs = append(errs, fmt.Errorf("config file %s: unknown setting %s", path, strings.Join(keyPath, ".")))
+				continue
+			}
+			if err := field.set(cfg, fileValue(raw)); err != nil {
+				errs = append(errs, fmt.Errorf("config file %s: %w", path, err))
+			}
+		}
+	}
+	apply(nil, data)
+	return errors.Join(errs...)
+}
+
+// fileValue formats a decoded file value like its environment variable.
+func fileValue(raw any) string {
+	switch v := raw.(type) {
+	case nil:
+		return ""
+	case []any:
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = fmt.Sprint(item)
+		}
+		return strings.Join(values, ",")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// normalizeKey lets groupID, group_id and group-id name the same setting.
+func normalizeKey(s string) string {
+	s = strings.ToLower(s)
+	return strings.NewReplacer("_", "", "-", "").Replace(s)
+}
+
+// upperSnake turns GroupID into GROUP_ID and CAFile into CA_FILE.
+func upperSnake(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,140 @@
+package ms
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sing3demons/profile-service/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+addr: "9000"
+kafka:
+  brokers: file:9092
+  groupId: from-file
+  commit_interval: 2s
+  sasl:
+    mechanism: SCRAM-SHA-512
+    username: svc
+mail:
+  host: smtp.file
+  auth:
+    username: mailer
+log:
+  detail:
+    logFile: true
+`)
+	t.Setenv(ConfigFileEnv, path)
+	t.Setenv("KAFKA_BROKERS", "env:9092")
+	t.Setenv("KAFKA_SASL_PASSWORD", "secret")
+	t.Setenv("DB_MAX_OPEN_CONNS", "20")
+
+	defaults := cfg
+	defaults.KafkaCfg.GroupID = "default"
+	loaded, args, err := LoadConfig(defaults, []string{"-kafka.brokers=flag:9092", "-kafka.manual-commit", "migrate", "up"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"migrate", "up"}, args)
+
+	assert.Equal(t, "9000", loaded.Addr)
+	assert.Equal(t, "flag:9092", loaded.KafkaCfg.Brokers)
+	assert.Equal(t, "from-file", loaded.KafkaCfg.GroupID)
+	assert.Equal(t, 2*time.Second, loaded.KafkaCfg.CommitInterval)
+	assert.True(t, loaded.KafkaCfg.ManualCommit)
+	assert.Equal(t, KafkaSASL{Mechanism: SASLScramSHA512, Username: "svc", Password: "secret"}, loaded.KafkaCfg.SASL)
+	assert.Equal(t, 20, loaded.Db.MaxOpenConns)
+	assert.Equal(t, "smtp.file", loaded.MailServer.Host)
+	assert.Equal(t, "mailer", loaded.MailServer.Auth.User)
+	assert.True(t, loaded.LogConfig.Detail.LogFile)
+
+	// settings kept from the defaults
+	assert.Equal(t, "profile-service", loaded.Name)
+	assert.Equal(t, cfg.LogConfig.Detail.LogDetail, loaded.LogConfig.Detail.LogDetail)
+}
+
+func TestLoadConfigJSONFile(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"redis": {"addr": "redis:6379", "db": 2}, "tracing": {"sampleRatio": 0.5}}`)
+
+	loaded, _, err := LoadConfig(cfg, []string{"-config", path})
+	assert.NoError(t, err)
+	assert.Equal(t, "redis:6379", loaded.RedisCfg.Addr)
+	assert.Equal(t, 2, loaded.RedisCfg.Db)
+	assert.Equal(t, 0.5, loaded.Tracing.SampleRatio)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	_, _, err := LoadConfig(cfg, []string{"-unknown"})
+	assert.Error(t, err)
+
+	_, _, err = LoadConfig(cfg, []string{"-config", writeConfigFile(t, "config.yaml", "kafka:\n  brokerz: x\n")})
+	assert.ErrorContains(t, err, "unknown setting kafka.brokerz")
+
+	t.Setenv("MAIL_PORT", "smtp")
+	_, _, err = LoadConfig(cfg, []string{"-kafka.commit-interval=soon"})
+	assert.ErrorContains(t, err, "$MAIL_PORT")
+	assert.ErrorContains(t, err, "kafka.commit-interval")
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, cfg.Validate())
+
+	invalid := cfg
+	invalid.Addr = ":http"
+	invalid.Name = ""
+	invalid.DrainDelay = -time.Second
+	invalid.Db.MaxIdleTime = "forever"
+	invalid.KafkaCfg.SASL = KafkaSASL{Mechanism: "GSSAPI", Username: "svc"}
+	invalid.Tracing.SampleRatio = 2
+	invalid.LogConfig.AppLog.Level = "verbose"
+	invalid.LogConfig.Detail.Sampling = []logger.SamplingRule{{Scenario: "get_user_by_id", Rate: 1.5}}
+	err := invalid.Validate()
+	for _, key := range []string{"addr", "service-name", "drain-delay", "db.max-idle-time", "kafka", "tracing.sample-ratio", "log.app-log.level", "log.detail"} {
+		assert.ErrorContains(t, err, key+":")
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	c := cfg
+	c.Db.Addr = "postgres://profile:p4ss@db:5432/profile?sslmode=disable"
+	c.RedisCfg.Pw = "redis-secret"
+	c.KafkaCfg.SASL = KafkaSASL{Mechanism: SASLPlain, Username: "svc", Password: "kafka-secret"}
+	c.KafkaCfg.CommitInterval = time.Second
+	c.MailServer.Auth = &Auth{User: "mailer", Pass: "mail-secret"}
+
+	redacted := c.Redacted()
+	assert.Equal(t, "postgres://profile:xxxxx@db:5432/profile?sslmode=disable", redacted["db.addr"])
+	assert.Equal(t, redactedValue, redacted["redis.password"])
+	assert.Equal(t, redactedValue, redacted["kafka.sasl.password"])
+	assert.Equal(t, redactedValue, redacted["mail.auth.password"])
+	assert.Equal(t, "svc", redacted["kafka.sasl.username"])
+	assert.Equal(t, "1s", redacted["kafka.commit-interval"])
+
+	s := c.String()
+	for _, secret := range []string{"p4ss", "redis-secret", "kafka-secret", "mail-secret"} {
+		assert.NotContains(t, s, secret)
+	}
+
+	assert.Equal(t, "user:******@tcp(db:3306)/profile", redactDSN("user:pass@tcp(db:3306)/profile"))
+	assert.Equal(t, "mock_db", redactDSN("mock_db"))
+}
+
+func TestUpperSnake(t *testing.T) {
+	for in, want := range map[string]string{
+		"GroupID":     "GROUP_ID",
+		"CAFile":      "CA_FILE",
+		"appLog":      "APP_LOG",
+		"SASL":        "SASL",
+		"MaxIdleTime": "MAX_IDLE_TIME",
+	} {
+		assert.Equal(t, want, upperSnake(in))
+	}
+}
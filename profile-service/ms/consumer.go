@@ -1,12 +1,15 @@
 package ms
 
 import (
+	"context"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 )
 
-func (ms *application) newKafkaConsumer(servers string, groupID string) (*kafka.Consumer, error) {
+func (ms *application) newKafkaConsumer(servers string, groupID string, overrides ...kafka.ConfigMap) (*kafka.Consumer, error) {
 	// Configurations
 	// https://github.com/edenhill/librdkafka/blob/master/CONFIGURATION.md
 	config := &kafka.ConfigMap{
@@ -24,10 +27,6 @@ func (ms *application) newKafkaConsumer(servers string, groupID string) (*kafka.
 		// 'error' - trigger an error which is retrieved by consuming messages and checking 'message->err'.
 		"auto.offset.reset": "earliest",
 
-		// Protocol used to communicate with brokers.
-		// plaintext, ssl, sasl_plaintext, sasl_ssl
-		"security.protocol": "plaintext",
-
 		// Automatically and periodically commit offsets in the background.
 		// Note: setting this to false does not prevent the consumer from fetching previously committed start offsets.
 		// To circumvent this behaviour set specific start offsets per partition in the call to assign().
@@ -47,6 +46,18 @@ func (ms *application) newKafkaConsumer(servers string, groupID string) (*kafka.
 		"socket.keepalive.enable": true,
 	}
 
+	// client id and security settings
+	client, err := ms.config.KafkaCfg.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, override := range append([]kafka.ConfigMap{client}, overrides...) {
+		for key, value := range override {
+			(*config)[key] = value
+		}
+	}
+
 	kc, err := kafka.NewConsumer(config)
 	if err != nil {
 		return nil, err
@@ -58,85 +69,227 @@ type consumerContext struct {
 	topic       string
 	topics      []string
 	readTimeout time.Duration
+	overrides   kafka.ConfigMap
+
+	workers      int
+	batchSize    int
+	batchWindow  time.Duration
+	batchHandler BatchHandleFunc
+
+	// subscription resolves the topic patterns, nil for a single topic.
+	subscription *topicSubscription
 }
 
 type kafkaMessage struct {
 	topic     string
+	partition int32
+	offset    int64
 	timestamp time.Time
 	key       string
 	value     string
+	headers   []kafka.Header
+}
+
+func newKafkaMessage(msg *kafka.Message) kafkaMessage {
+	return kafkaMessage{
+		topic:     *msg.TopicPartition.Topic,
+		partition: msg.TopicPartition.Partition,
+		offset:    int64(msg.TopicPartition.Offset),
+		timestamp: msg.Timestamp,
+		value:     string(msg.Value),
+		key:       string(msg.Key),
+		headers:   msg.Headers,
+	}
 }
 
 func (ms *application) consumeSingle(ctx consumerContext, h ServiceHandleFunc) {
-	c, err := ms.newKafkaConsumer(ms.config.KafkaCfg.Brokers, ms.config.KafkaCfg.GroupID)
+	ms.consume(ctx, h, func(c *kafka.Consumer, cb kafka.RebalanceCb) error {
+		return c.Subscribe(ctx.topic, cb)
+	})
+}
+
+func (ms *application) consumeMultiple(ctx consumerContext, h ServiceHandleFunc) {
+	ctx.subscription = ms.newTopicSubscription(ctx.topics)
+	ms.consume(ctx, h, ctx.subscription.subscribe)
+}
+
+func (ms *application) consume(ctx consumerContext, h ServiceHandleFunc, subscribe func(*kafka.Consumer, kafka.RebalanceCb) error) {
+	defer ms.consumers.Done()
+
+	// offsets are stored once their message is done with, librdkafka auto commit only
+	// commits the stored ones so a failed message is not skipped
+	overrides := kafka.ConfigMap{"enable.auto.offset.store": false}
+	if ms.config.KafkaCfg.ManualCommit {
+		overrides["enable.auto.commit"] = false
+	}
+
+	c, err := ms.newKafkaConsumer(ms.config.KafkaCfg.Brokers, ms.config.KafkaCfg.GroupID, overrides, ctx.overrides)
 	if err != nil {
 		ms.Log("Consumer", err.Error())
 		return
 	}
 	defer c.Close()
 
-	c.Subscribe(ctx.topic, nil)
+	committer := newOffsetCommitter(c, ms.config.KafkaCfg, ms.Log)
+	var rebalanceCb kafka.RebalanceCb = committer.rebalance
 
-	for {
-		ms.processMessage(ctx, c, h)
+	var pool *workerPool
+	if ctx.workers > 1 && ctx.batchHandler == nil {
+		pool = ms.newWorkerPool(ctx.workers, h)
+		rebalanceCb = pool.rebalance(committer)
 	}
-}
-func (ms *application) consumeMultiple(ctx consumerContext, h ServiceHandleFunc) {
-	c, err := ms.newKafkaConsumer(ms.config.KafkaCfg.Brokers, ms.config.KafkaCfg.GroupID)
-	if err != nil {
+
+	if err := subscribe(c, rebalanceCb); err != nil {
 		ms.Log("Consumer", err.Error())
+		if pool != nil {
+			pool.close()
+		}
+		return
+	}
+
+	switch {
+	case ctx.batchHandler != nil:
+		ms.consumeBatches(ctx, c, committer)
+		return
+	case pool != nil:
+		ms.consumeConcurrently(ctx, c, committer, pool)
 		return
 	}
-	c.SubscribeTopics(ctx.topics, nil)
 
 	for {
-		ms.processMessage(ctx, c, h)
+		select {
+		case <-ms.stopConsumers:
+			committer.commit()
+			return
+		default:
+			ms.processMessage(ctx, c, committer, h)
+		}
 	}
 }
 
-func (ms *application) processMessage(ctx consumerContext, c *kafka.Consumer, h ServiceHandleFunc) {
+func (ms *application) processMessage(ctx consumerContext, c *kafka.Consumer, committer *offsetCommitter, h ServiceHandleFunc) {
 	if ctx.readTimeout <= 0 {
-		// readtimeout -1 indicates no timeout
-		ctx.readTimeout = -1
+		ctx.readTimeout = consumerPollTimeout
 	}
 
+	ctx.subscription.refresh(c)
 	msg, err := c.ReadMessage(ctx.readTimeout)
 	if err != nil {
 		ms.handleKafkaError(ctx, err)
+		committer.maybeCommit()
 		return
 	}
 
+	ms.observeConsumed(c, msg)
+
 	// Execute Handler
-	h(NewConsumerContext(kafkaMessage{
-		topic:     *msg.TopicPartition.Topic,
-		timestamp: msg.Timestamp,
-		value:     string(msg.Value),
-		key:       string(msg.Key),
-	}, ms))
+	start := time.Now()
+	done := ms.handleMessage(context.Background(), newKafkaMessage(msg), h)
+	ms.metrics.consumerDuration.WithLabelValues(*msg.TopicPartition.Topic).Observe(time.Since(start).Seconds())
+
+	if !done {
+		// rewind so the message is delivered again, nothing after it is stored meanwhile
+		if err := c.Seek(msg.TopicPartition, 0); err != nil {
+			ms.Log("Consumer", fmt.Sprintf("seek %s: %s", msg.TopicPartition, err.Error()))
+		}
+		ms.sleep(context.Background(), redeliveryBackoff)
+		return
+	}
+
+	committer.store(msg)
 }
 
 func (ms *application) handleKafkaError(ctx consumerContext, err error) {
 	kafkaErr, ok := err.(kafka.Error)
 	if ok {
 		if kafkaErr.Code() == kafka.ErrTimedOut {
-			if ctx.readTimeout == -1 {
-				// No timeout just continue to read message again
-				return
-			}
+			// No message within readTimeout just continue to read message again
+			return
 		}
 	}
 	ms.Log("Consumer", err.Error())
 }
 
-// Consume register service endpoint for Consumer service
-func (ms *application) Consume(topic string, h ServiceHandleFunc) error {
+// observeConsumed counts msg and updates the partition lag from the locally cached high watermark.
+func (ms *application) observeConsumed(c *kafka.Consumer, msg *kafka.Message) {
+	tp := msg.TopicPartition
+	ms.metrics.consumerMessages.WithLabelValues(*tp.Topic).Inc()
+
+	_, high, err := c.GetWatermarkOffsets(*tp.Topic, tp.Partition)
+	if err != nil || high < 0 {
+		return
+	}
+	lag := high - int64(tp.Offset) - 1
+	if lag < 0 {
+		lag = 0
+	}
+	ms.metrics.consumerLag.WithLabelValues(*tp.Topic, strconv.Itoa(int(tp.Partition))).Set(float64(lag))
+}
+
+// sleep pauses for d and reports false when the consumers are stopped or ctx is done in the meantime.
+func (ms *application) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ms.stopConsumers:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Consume register service endpoint for Consumer service. WithWorkers handles the messages
+// concurrently, the retry topics are always consumed one message at a time.
+func (ms *application) Consume(topic string, h ServiceHandleFunc, opts ...ConsumeOption) error {
 	// if ms.consumer == nil {
 	// 	ms.Log("Consumer", fmt.Sprintf("Consumer is not initialized for topic %s", topic))
 	// 	return errors.New("consumer is not initialized")
 	// }
-	go ms.consumeSingle(consumerContext{
-		topic:       topic,
-		readTimeout: time.Duration(-1),
-	}, h)
+	ctx := consumerContext{
+		topic: topic,
+	}
+	for _, opt := range opts {
+		opt(&ctx)
+	}
+	return ms.register(ctx, h)
+}
+
+// register starts the consumer of ctx and those of the retry topics of its topics, which run h.
+func (ms *application) register(ctx consumerContext, h ServiceHandleFunc) error {
+	ms.consumers.Add(1)
+	if len(ctx.topics) > 0 {
+		go ms.consumeMultiple(ctx, h)
+	} else {
+		go ms.consumeSingle(ctx, h)
+	}
+
+	// one consumer per delay, shared by the retry topics of every subscribed topic
+	retryTopics := map[time.Duration][]string{}
+	for _, topic := range ms.retriedTopics(ctx) {
+		policy, _ := ms.retryPolicy(topic)
+		for _, delay := range policy.Delays {
+			retryTopics[delay] = append(retryTopics[delay], RetryTopic(topic, delay))
+		}
+	}
+	for delay, topics := range retryTopics {
+		// the consumer sleeps until the head message is due, it must not be evicted from the group meanwhile
+		maxPollInterval := delay + 5*time.Minute
+		retryCtx := consumerContext{
+			overrides: kafka.ConfigMap{
+				"max.poll.interval.ms": int(maxPollInterval.Milliseconds()),
+			},
+		}
+		ms.consumers.Add(1)
+		if len(topics) == 1 {
+			retryCtx.topic = topics[0]
+			go ms.consumeSingle(retryCtx, h)
+		} else {
+			retryCtx.topics = topics
+			go ms.consumeMultiple(retryCtx, h)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,277 @@
+package ms
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// topicRefreshInterval is how often the topics matching the patterns of a subscription are
+// listed again, so the topics created meanwhile are consumed.
+var topicRefreshInterval = time.Minute
+
+// ConsumeTopics registers h for every topic on a single consumer of the group. An entry
+// starting with ^ is a regular expression matched against the broker topics, e.g.
+// `^service\.[a-z]+$`. The retry and dead-letter topics are never matched, they have their
+// own consumers.
+func (ms *application) ConsumeTopics(topics []string, h ServiceHandleFunc, opts ...ConsumeOption) error {
+	if len(topics) == 0 {
+		return errors.New("no topic to consume")
+	}
+	for _, topic := range topics {
+		if isTopicPattern(topic) {
+			if _, err := regexp.Compile(topic); err != nil {
+				return fmt.Errorf("topic pattern %s: %w", topic, err)
+			}
+		}
+	}
+
+	ctx := consumerContext{
+		topics: topics,
+	}
+	for _, opt := range opts {
+		opt(&ctx)
+	}
+	return ms.register(ctx, h)
+}
+
+// ConsumeRoutes consumes every key of routes with ConsumeTopics and runs the handler of the
+// message topic: the exact key first, then the first matching pattern in key order.
+func (ms *application) ConsumeRoutes(routes map[string]ServiceHandleFunc, opts ...ConsumeOption) error {
+	topics := make([]string, 0, len(routes))
+	for topic := range routes {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	r, err := newTopicRouter(topics, routes, ms.isRetryOrDeadLetterTopic)
+	if err != nil {
+		return err
+	}
+	return ms.ConsumeTopics(topics, r.handle, opts...)
+}
+
+func isTopicPattern(topic string) bool {
+	return strings.HasPrefix(topic, "^")
+}
+
+type topicRoute struct {
+	pattern *regexp.Regexp
+	h       ServiceHandleFunc
+}
+
+// topicRouter picks the handler of a message from its original topic, so the retried
+// messages reach the handler of the topic they failed on.
+type topicRouter struct {
+	topics   map[string]ServiceHandleFunc
+	patterns []topicRoute
+	// internal reports the retry and dead-letter topics the patterns never match.
+	internal func(topic string) bool
+}
+
+func newTopicRouter(topics []string, routes map[string]ServiceHandleFunc, internal func(topic string) bool) (*topicRouter, error) {
+	r := &topicRouter{topics: map[string]ServiceHandleFunc{}, internal: internal}
+	for _, topic := range topics {
+		if !isTopicPattern(topic) {
+			r.topics[topic] = routes[topic]
+			continue
+		}
+		pattern, err := regexp.Compile(topic)
+		if err != nil {
+			return nil, fmt.Errorf("topic pattern %s: %w", topic, err)
+		}
+		r.patterns = append(r.patterns, topicRoute{pattern: pattern, h: routes[topic]})
+	}
+	return r, nil
+}
+
+func (r *topicRouter) route(topic string) (ServiceHandleFunc, bool) {
+	if h, ok := r.topics[topic]; ok {
+		return h, true
+	}
+	if r.internal(topic) {
+		return nil, false
+	}
+	for _, route := range r.patterns {
+		if route.pattern.MatchString(topic) {
+			return route.h, true
+		}
+	}
+	return nil, false
+}
+
+func (r *topicRouter) handle(c IContext) error {
+	topic := c.(*ConsumerContext).message.originalTopic()
+	h, ok := r.route(topic)
+	if !ok {
+		return fmt.Errorf("no handler for topic %s", topic)
+	}
+	return h(c)
+}
+
+// retriedTopics returns the topics of ctx with a retry policy, the patterns match the
+// topics of the configured policies.
+func (ms *application) retriedTopics(ctx consumerContext) []string {
+	topics := ctx.topics
+	if len(topics) == 0 {
+		topics = []string{ctx.topic}
+	}
+
+	seen := map[string]bool{}
+	var retried []string
+	add := func(topic string) {
+		if !seen[topic] {
+			seen[topic] = true
+			retried = append(retried, topic)
+		}
+	}
+
+	for _, topic := range topics {
+		if !isTopicPattern(topic) {
+			if _, ok := ms.retryPolicy(topic); ok {
+				add(topic)
+			}
+			continue
+		}
+
+		pattern, err := regexp.Compile(topic)
+		if err != nil {
+			continue
+		}
+		for policyTopic := range ms.config.KafkaCfg.Retry {
+			if pattern.MatchString(policyTopic) {
+				add(policyTopic)
+			}
+		}
+	}
+	sort.Strings(retried)
+	return retried
+}
+
+// isRetryOrDeadLetterTopic reports whether topic is a `<topic>.retry.<delay>` topic or a
+// dead-letter topic, the default `<topic>.dlq` or one set in a RetryPolicy.
+func (ms *application) isRetryOrDeadLetterTopic(topic string) bool {
+	if strings.HasSuffix(topic, ".dlq") {
+		return true
+	}
+	if i := strings.LastIndex(topic, ".retry."); i >= 0 {
+		if _, err := time.ParseDuration(topic[i+len(".retry."):]); err == nil {
+			return true
+		}
+	}
+	for _, policy := range ms.config.KafkaCfg.Retry {
+		if policy.DeadLetterTopic == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// topicSubscription subscribes a consumer to its topics. The patterns are resolved against
+// the broker topics rather than given to the consumer, so they never pick the retry and
+// dead-letter topics of the group.
+type topicSubscription struct {
+	ms       *application
+	topics   []string
+	patterns []*regexp.Regexp
+	cb       kafka.RebalanceCb
+	current  []string
+	next     time.Time
+}
+
+func (ms *application) newTopicSubscription(topics []string) *topicSubscription {
+	s := &topicSubscription{ms: ms}
+	for _, topic := range topics {
+		if !isTopicPattern(topic) {
+			s.topics = append(s.topics, topic)
+			continue
+		}
+		// ConsumeTopics validated the patterns
+		s.patterns = append(s.patterns, regexp.MustCompile(topic))
+	}
+	return s
+}
+
+func (s *topicSubscription) subscribe(c *kafka.Consumer, cb kafka.RebalanceCb) error {
+	s.cb = cb
+	if len(s.patterns) == 0 {
+		return c.SubscribeTopics(s.topics, cb)
+	}
+	topics, err := s.resolve(c)
+	if err != nil {
+		return err
+	}
+	s.current = topics
+	s.next = time.Now().Add(topicRefreshInterval)
+	return c.SubscribeTopics(topics, cb)
+}
+
+// refresh subscribes c again when the topics matching the patterns changed, it is called
+// from the goroutine polling c.
+func (s *topicSubscription) refresh(c *kafka.Consumer) {
+	if s == nil || len(s.patterns) == 0 || time.Now().Before(s.next) {
+		return
+	}
+	s.next = time.Now().Add(topicRefreshInterval)
+
+	topics, err := s.resolve(c)
+	if err != nil {
+		s.ms.Log("Consumer", "list topics: "+err.Error())
+		return
+	}
+	if slices.Equal(topics, s.current) {
+		return
+	}
+	if err := c.SubscribeTopics(topics, s.cb); err != nil {
+		s.ms.Log("Consumer", err.Error())
+		return
+	}
+	s.current = topics
+}
+
+func (s *topicSubscription) resolve(c *kafka.Consumer) ([]string, error) {
+	metadata, err := c.GetMetadata(nil, true, int(10*time.Second/time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(metadata.Topics))
+	for name := range metadata.Topics {
+		names = append(names, name)
+	}
+	return s.match(names), nil
+}
+
+// match returns the sorted topics of the subscription among the broker topics.
+func (s *topicSubscription) match(brokerTopics []string) []string {
+	seen := map[string]bool{}
+	var topics []string
+	add := func(topic string) {
+		if !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+
+	for _, topic := range s.topics {
+		add(topic)
+	}
+	for _, topic := range brokerTopics {
+		if s.ms.isRetryOrDeadLetterTopic(topic) || strings.HasPrefix(topic, "__") {
+			continue
+		}
+		for _, pattern := range s.patterns {
+			if pattern.MatchString(topic) {
+				add(topic)
+				break
+			}
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
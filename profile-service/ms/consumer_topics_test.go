@@ -0,0 +1,105 @@
+package ms
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicRouter(t *testing.T) {
+	var called string
+	handler := func(name string) ServiceHandleFunc {
+		return func(IContext) error {
+			called = name
+			return nil
+		}
+	}
+
+	routes := map[string]ServiceHandleFunc{
+		"service.register":   handler("register"),
+		`^service\.[a-z]+$`:  handler("service"),
+		`^billing\.[a-z]+$`:  handler("billing"),
+		`^service\.au[a-z]+`: handler("unreached"),
+	}
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	r, err := newTopicRouter([]string{`^billing\.[a-z]+$`, `^service\.[a-z]+$`, `^service\.au[a-z]+`, "service.register"}, routes, app.isRetryOrDeadLetterTopic)
+	assert.NoError(t, err)
+	run := func(msg *kafka.Message) error {
+		called = ""
+		return r.handle(newConsumerContext(context.Background(), newKafkaMessage(msg), app))
+	}
+
+	assert.NoError(t, run(testMessage("service.register", 0, 1, "", 1)))
+	assert.Equal(t, "register", called)
+
+	assert.NoError(t, run(testMessage("service.auth", 0, 1, "", 1)))
+	assert.Equal(t, "service", called)
+
+	// a retried message goes back to the handler of the topic it failed on
+	retried := testMessage(RetryTopic("service.register", time.Minute), 0, 1, "", 1)
+	retried.Headers = []kafka.Header{{Key: HeaderOriginalTopic, Value: []byte("service.register")}}
+	assert.NoError(t, run(retried))
+	assert.Equal(t, "register", called)
+
+	assert.Error(t, run(testMessage("orders.created", 0, 1, "", 1)))
+	assert.Empty(t, called)
+
+	_, err = newTopicRouter([]string{"^service.("}, nil, app.isRetryOrDeadLetterTopic)
+	assert.Error(t, err)
+}
+
+func TestTopicPatternSkipsRetryAndDeadLetterTopics(t *testing.T) {
+	c := cfg
+	c.KafkaCfg.Retry = map[string]RetryPolicy{
+		"service.verify": {DeadLetterTopic: "service.parked"},
+	}
+	app := NewApplication(c).(*application)
+	t.Cleanup(app.CleanUp)
+
+	sub := app.newTopicSubscription([]string{`^service\..*`, "billing.invoice"})
+	assert.Equal(t, []string{"billing.invoice", "service.register", "service.verify"}, sub.match([]string{
+		"service.register", "service.register.dlq", "service.register.retry.1m", "service.register.retry.1h30m",
+		"service.verify", "service.parked", "orders.created", "__consumer_offsets",
+	}))
+
+	r, err := newTopicRouter([]string{`^service\..*`}, map[string]ServiceHandleFunc{
+		`^service\..*`: func(IContext) error { return nil },
+	}, app.isRetryOrDeadLetterTopic)
+	assert.NoError(t, err)
+	_, ok := r.route("service.register")
+	assert.True(t, ok)
+	for _, topic := range []string{"service.register.dlq", "service.register.retry.1m", "service.parked"} {
+		_, ok := r.route(topic)
+		assert.False(t, ok, topic)
+	}
+}
+
+func TestRetriedTopics(t *testing.T) {
+	c := cfg
+	c.KafkaCfg.Retry = map[string]RetryPolicy{
+		"service.register": {Delays: []time.Duration{time.Minute}},
+		"service.auth":     {Delays: []time.Duration{time.Minute}},
+		"billing.invoice":  {},
+	}
+	app := NewApplication(c).(*application)
+	t.Cleanup(app.CleanUp)
+
+	assert.Equal(t, []string{"service.register"}, app.retriedTopics(consumerContext{topic: "service.register"}))
+	assert.Empty(t, app.retriedTopics(consumerContext{topic: "service.verify"}))
+	assert.Equal(t, []string{"billing.invoice", "service.auth", "service.register"},
+		app.retriedTopics(consumerContext{topics: []string{`^service\..*`, "billing.invoice", "service.auth"}}))
+}
+
+func TestConsumeTopicsValidation(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	h := func(IContext) error { return nil }
+	assert.Error(t, app.ConsumeTopics(nil, h))
+	assert.Error(t, app.ConsumeTopics([]string{"service.auth", "^service.("}, h))
+}
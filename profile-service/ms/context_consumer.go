@@ -7,7 +7,6 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/google/uuid"
 	"github.com/sing3demons/profile-service/constants"
 	"github.com/sing3demons/profile-service/logger"
 	"github.com/sing3demons/profile-service/utils"
@@ -15,49 +14,92 @@ import (
 
 // NewConsumerContext is the constructor function for ConsumerContext
 func NewConsumerContext(message kafkaMessage, ms *application) IContext {
+	return newConsumerContext(context.Background(), message, ms)
+}
+
+func newConsumerContext(ctx context.Context, message kafkaMessage, ms *application) *ConsumerContext {
+	session := consumerSession(message)
 	return &ConsumerContext{
+		ctx:     utils.ContextWithSession(ctx, session),
 		message: message,
 		ms:      ms,
+		session: session,
+	}
+}
+
+// consumerSession continues the producer session: the payload header first, then the
+// x-session Kafka header, and only then a new one.
+func consumerSession(message kafkaMessage) string {
+	payload := Payload{}
+	if err := json.Unmarshal([]byte(message.value), &payload); err == nil && payload.Header.Session != "" {
+		return payload.Header.Session
+	}
+	if session, ok := message.header(constants.KafkaSessionHeader); ok && session != "" {
+		return session
+	}
+	return GenerateXTid(message.topic)
+}
+
+// Bind decodes the payload body, or the whole message when it is not a Payload, and the
+// Kafka headers into dst. Consumers have no query string nor path parameters.
+func (h *ConsumerContext) Bind(dst any) error {
+	body := []byte(h.message.value)
+	payload := Payload{}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Body != nil {
+		b, err := json.Marshal(payload.Body)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	headers := http.Header{}
+	for _, header := range h.message.headers {
+		headers.Add(header.Key, string(header.Value))
 	}
+	headers.Set(constants.SessionHeader, h.session)
+
+	return bind(dst, bindSource{body: body, headers: headers})
+}
+
+func (h *ConsumerContext) Context() context.Context {
+	return h.ctx
 }
 
 func (h *ConsumerContext) CommonLog(initInvoke, cmd, identity string) (logger.DetailLog, logger.SummaryLog) {
 	if utils.IsStructEmpty(h.payload) {
 		h.payload = h.Payload()
 	}
-	if h.payload.Header.Session == "" {
-		h.payload.Header.Session = fmt.Sprintf("%s-%s", cmd, uuid.New().String())
-	}
 	req := &http.Request{}
-	req = req.WithContext(context.WithValue(req.Context(), constants.Session, h.payload.Header.Session))
-
-	conf := logger.LogConfig{}
-	conf.ProjectName = h.ms.config.LogConfig.ProjectName
-	conf.Namespace = h.ms.config.LogConfig.Namespace
-	conf.Summary.RawData = h.ms.config.LogConfig.Summary.RawData
-	conf.Summary.LogFile = h.ms.config.LogConfig.Summary.LogFile
-	conf.Summary.LogConsole = h.ms.config.LogConfig.Summary.LogConsole
-	conf.Summary.LogSummary = h.ms.config.LogConfig.Summary.LogSummary
+	req = req.WithContext(h.ctx)
 
-	conf.Detail.RawData = h.ms.config.LogConfig.Detail.RawData
-	conf.Detail.LogFile = h.ms.config.LogConfig.Detail.LogFile
-	conf.Detail.LogConsole = h.ms.config.LogConfig.Detail.LogConsole
-	conf.Detail.LogDetail = h.ms.config.LogConfig.Detail.LogDetail
+	conf := h.ms.logConfig()
 
-	detailLog := logger.NewDetailLog(req, initInvoke, cmd, identity, conf)
-	summaryLog := logger.NewSummaryLog(req, initInvoke, cmd, conf)
+	detailLog, summaryLog := logger.NewLogs(req, initInvoke, cmd, identity, conf)
 
 	detailLog.AddInputRequest(constants.CLIENT, cmd, initInvoke, nil, h.payload)
 	h.l = detailLog
 	h.s = summaryLog
+	h.ctx = logger.NewContext(h.ctx, detailLog, summaryLog)
 	h.invoke = initInvoke
 	h.topic = cmd
 	return h.l, h.s
 }
 
-func (h *ConsumerContext) SendKafkaMessage(topic string, payload any) error {
+func (h *ConsumerContext) SendKafkaMessage(topic string, payload any, opts ...KafkaOption) error {
+	m := newOutgoingMessage(topic, payload, opts)
+	if m.deferred {
+		h.deferred.add(m)
+		return nil
+	}
+	return h.sendKafkaMessage(m)
+}
 
-	return nil
+func (h *ConsumerContext) sendKafkaMessage(m outgoingMessage) error {
+	if h.l == nil {
+		h.CommonLog(GenerateXTid("kafka"), h.message.topic, "anonymous")
+	}
+	return h.ms.sendKafkaMessage(m, h.l, h.s)
 }
 
 // Log will log a message
@@ -78,7 +120,7 @@ func (ctx *ConsumerContext) ReadInput() InComing {
 		msg := Msg{
 			Topic: ctx.topic,
 			Header: Header{
-				Session: GenerateXTid(ctx.topic),
+				Session: ctx.session,
 			},
 			Body: "",
 		}
@@ -90,9 +132,7 @@ func (ctx *ConsumerContext) ReadInput() InComing {
 		return data
 	}
 
-	if payload.Header.Session == "" {
-		payload.Header.Session = GenerateXTid(ctx.topic)
-	}
+	payload.Header.Session = ctx.session
 
 	msg := Msg{
 		Topic:  ctx.topic,
@@ -116,7 +156,7 @@ func (ctx *ConsumerContext) Payload() Msg {
 		data := Msg{
 			Topic: ctx.topic,
 			Header: Header{
-				Session: GenerateXTid(ctx.topic),
+				Session: ctx.session,
 			},
 			Body: "",
 		}
@@ -124,9 +164,7 @@ func (ctx *ConsumerContext) Payload() Msg {
 		return data
 	}
 
-	if payload.Header.Session == "" {
-		payload.Header.Session = GenerateXTid(ctx.topic)
-	}
+	payload.Header.Session = ctx.session
 
 	data := Msg{
 		Topic:  ctx.topic,
@@ -138,12 +176,15 @@ func (ctx *ConsumerContext) Payload() Msg {
 	return data
 }
 
-// Response return response to client
+// Response ends the logs of the message, the summary result is derived like for HTTPContext.
+// A deferred Kafka message that fails to send is returned, so the retry policy applies.
 func (ctx *ConsumerContext) Response(responseCode int, responseData interface{}) error {
+	responseCode, responseData, err := ctx.deferred.settle(responseCode, responseData, ctx.sendKafkaMessage)
+	_, body, resultCode, resultDesc := ctx.ms.envelope(responseCode, responseData)
 
 	if ctx.l != nil {
 		log := ctx.l
-		log.AddOutputResponse("consume", ctx.topic, ctx.invoke, responseData, responseData)
+		log.AddOutputResponse("consume", ctx.topic, ctx.invoke, body, body)
 		log.AutoEnd()
 		ctx.l = nil
 	}
@@ -151,8 +192,6 @@ func (ctx *ConsumerContext) Response(responseCode int, responseData interface{})
 	if ctx.s != nil {
 		s := *&ctx.s
 		if !s.IsEnd() {
-			resultCode := fmt.Sprintf("%d", responseCode)
-			resultDesc := http.StatusText(responseCode)
 			s.End(resultCode, resultDesc)
 			s = nil
 		}
@@ -161,10 +200,10 @@ func (ctx *ConsumerContext) Response(responseCode int, responseData interface{})
 	log.Println(fmt.Sprintf("Consumer [%v] -> Response: [%s]\n", ctx.topic, responseData))
 	ctx = nil
 
-	return nil
+	return err
 }
 func (h *ConsumerContext) SendMail(message Message) error {
-	result := sendMail(h.ms.config.MailServer, message, h.l, h.s)
+	result := h.ms.sendMail(h.ctx, message)
 	if result.Err {
 		return fmt.Errorf("Error sending email: %s", result.ResultDesc)
 	}
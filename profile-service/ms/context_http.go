@@ -2,6 +2,7 @@ package ms
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,6 +14,10 @@ import (
 )
 
 func NewHTTPContext(res http.ResponseWriter, req *http.Request, ms *application) IContext {
+	return newHTTPContext(res, req, ms)
+}
+
+func newHTTPContext(res http.ResponseWriter, req *http.Request, ms *application) *HTTPContext {
 	return &HTTPContext{
 		Res: res,
 		Req: req,
@@ -41,42 +46,83 @@ func (h *HTTPContext) ReadInput() InComing {
 	return data
 }
 
+func (h *HTTPContext) Bind(dst any) error {
+	bodyBytes, err := io.ReadAll(h.Req.Body)
+	if err != nil {
+		return err
+	}
+	h.Req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	return bind(dst, bindSource{
+		body:    bodyBytes,
+		query:   h.Req.URL.Query(),
+		params:  mux.Vars(h.Req),
+		headers: h.Req.Header,
+	})
+}
+
+func (h *HTTPContext) Context() context.Context {
+	return h.Req.Context()
+}
+
 func (h *HTTPContext) Param(key string) string {
 	return mux.Vars(h.Req)[key]
 }
 
+// Response writes responseData in the standard envelope. An error is resolved to an AppError,
+// see MapError, whose status replaces responseCode. Deferred Kafka messages are sent first
+// for a success; when one fails, the error is answered and returned.
 func (h *HTTPContext) Response(responseCode int, responseData interface{}) error {
-	h.JSON(responseCode, responseData)
-	return nil
+	responseCode, responseData, err := h.deferred.settle(responseCode, responseData, h.sendKafkaMessage)
+	status, body, resultCode, resultDesc := h.ms.envelope(responseCode, responseData)
+
+	var data interface{} = body
+	if status == http.StatusNoContent {
+		data = nil
+	}
+	h.write(status, data, resultCode, resultDesc)
+	return err
 }
 
 func (h *HTTPContext) CommonLog(initInvoke, scenario, identity string) (logger.DetailLog, logger.SummaryLog) {
 
-	conf := logger.LogConfig{}
-	conf.ProjectName = h.ms.config.LogConfig.ProjectName
-	conf.Namespace = h.ms.config.LogConfig.Namespace
+	conf := h.ms.logConfig()
 
-	conf.Summary.RawData = h.ms.config.LogConfig.Summary.RawData
-	conf.Summary.LogFile = h.ms.config.LogConfig.Summary.LogFile
-	conf.Summary.LogConsole = h.ms.config.LogConfig.Summary.LogConsole
-	conf.Summary.LogSummary = h.ms.config.LogConfig.Summary.LogSummary
-
-	conf.Detail.RawData = h.ms.config.LogConfig.Detail.RawData
-	conf.Detail.LogFile = h.ms.config.LogConfig.Detail.LogFile
-	conf.Detail.LogConsole = h.ms.config.LogConfig.Detail.LogConsole
-	conf.Detail.LogDetail = h.ms.config.LogConfig.Detail.LogDetail
-
-	detailLog := logger.NewDetailLog(h.Req, initInvoke, scenario, identity, conf)
-	h.s = logger.NewSummaryLog(h.Req, initInvoke, scenario, conf)
+	detailLog, summaryLog := logger.NewLogs(h.Req, initInvoke, scenario, identity, conf)
+	h.s = summaryLog
 	h.intInvoke = initInvoke
 	h.scenario = scenario
 	detailLog.AddInputRequest(constants.CLIENT, scenario, initInvoke, nil, h.ReadInput())
 	h.l = detailLog
+	h.Req = h.Req.WithContext(logger.NewContext(h.Req.Context(), h.l, h.s))
 	return h.l, h.s
 }
 
+// JSON writes data as is, without the response envelope.
 func (h *HTTPContext) JSON(code int, data interface{}) {
+	h.write(code, data, fmt.Sprintf("%d", code), http.StatusText(code))
+}
 
+func (h *HTTPContext) write(code int, data interface{}, resultCode, resultDesc string) {
+	h.written = true
+	h.endLogs(data, resultCode, resultDesc)
+
+	if data == nil {
+		h.Res.WriteHeader(code)
+		return
+	}
+
+	h.Res.Header().Set(constants.ContentType, constants.ContentTypeJSON)
+	h.Res.WriteHeader(code)
+	json.NewEncoder(h.Res).Encode(data)
+
+	// if h.Log != nil {
+	// 	h.Log.End()
+	// }
+}
+
+// endLogs ends the logs of the request with the response data and summary result.
+func (h *HTTPContext) endLogs(data interface{}, resultCode, resultDesc string) {
 	if h.l != nil {
 		h.l.AddOutputRequest(constants.CLIENT, h.scenario, h.intInvoke, data, data)
 		h.l.AutoEnd()
@@ -86,31 +132,16 @@ func (h *HTTPContext) JSON(code int, data interface{}) {
 	if h.s != nil {
 		s := *&h.s
 		if !s.IsEnd() {
-			resultCode := fmt.Sprintf("%d", code)
-			resultDesc := http.StatusText(code)
 			s.End(resultCode, resultDesc)
 			s = nil
 		}
 	}
 	h.intInvoke = ""
 	h.scenario = ""
-
-	h.Res.Header().Set(constants.ContentType, constants.ContentTypeJSON)
-	h.Res.WriteHeader(code)
-	json.NewEncoder(h.Res).Encode(data)
-	h = nil
-
-	// if h.Log != nil {
-	// 	h.Log.End()
-	// }
 }
 
 func (b *HTTPContext) Error(code int, err error) {
-	data := map[string]interface{}{
-		"message": err.Error(),
-	}
-
-	b.JSON(code, data)
+	b.Response(code, err)
 }
 
 func (h *HTTPContext) GetSession() string {
@@ -118,14 +149,25 @@ func (h *HTTPContext) GetSession() string {
 }
 
 func (h *HTTPContext) SendMail(message Message) error {
-	result := sendMail(h.ms.config.MailServer, message, h.l, h.s)
+	result := h.ms.sendMail(h.Context(), message)
 	if result.Err {
 		return fmt.Errorf("Error sending email: %s", result.ResultDesc)
 	}
 	return nil
 }
 
-func (h *HTTPContext) SendKafkaMessage(topic string, payload any) error {
+func (h *HTTPContext) SendKafkaMessage(topic string, payload any, opts ...KafkaOption) error {
+	m := newOutgoingMessage(topic, payload, opts)
+	if m.deferred {
+		h.deferred.add(m)
+		return nil
+	}
+	return h.sendKafkaMessage(m)
+}
 
-	return nil
+func (h *HTTPContext) sendKafkaMessage(m outgoingMessage) error {
+	if h.l == nil {
+		h.CommonLog(GenerateXTid("kafka"), "send_kafka_message", "anonymous")
+	}
+	return h.ms.sendKafkaMessage(m, h.l, h.s)
 }
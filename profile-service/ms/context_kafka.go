@@ -0,0 +1,93 @@
+package ms
+
+import (
+	"net/http"
+
+	"github.com/sing3demons/profile-service/logger"
+)
+
+// KafkaOption customises a message sent with IContext.SendKafkaMessage.
+type KafkaOption func(*outgoingMessage)
+
+// WithKey sets the message key, messages with the same key go to the same partition.
+func WithKey(key string) KafkaOption {
+	return func(m *outgoingMessage) {
+		m.key = key
+	}
+}
+
+// WithHeaders adds Kafka headers to the message.
+func WithHeaders(headers map[string]string) KafkaOption {
+	return func(m *outgoingMessage) {
+		if m.headers == nil {
+			m.headers = map[string]string{}
+		}
+		for k, v := range headers {
+			m.headers[k] = v
+		}
+	}
+}
+
+// Deferred holds the message until the handler succeeds: it is sent when the handler responds
+// with a success status or returns nil, and dropped when it fails.
+func Deferred() KafkaOption {
+	return func(m *outgoingMessage) {
+		m.deferred = true
+	}
+}
+
+type outgoingMessage struct {
+	topic    string
+	key      string
+	headers  map[string]string
+	payload  any
+	deferred bool
+}
+
+func newOutgoingMessage(topic string, payload any, opts []KafkaOption) outgoingMessage {
+	m := outgoingMessage{topic: topic, payload: payload}
+	for _, opt := range opts {
+		opt(&m)
+	}
+	return m
+}
+
+// message wraps the payload in the standard Payload envelope, the producer stamps the session.
+func (m outgoingMessage) message() any {
+	switch m.payload.(type) {
+	case Payload, *Payload, Msg, *Msg:
+		return m.payload
+	}
+	return Payload{Body: m.payload}
+}
+
+func (app *application) sendKafkaMessage(m outgoingMessage, detailLog logger.DetailLog, summaryLog logger.SummaryLog) error {
+	return app.sharedProducer().send(m.topic, m.key, m.message(), m.headers, detailLog, summaryLog)
+}
+
+// deferredMessages holds the messages a handler sent with Deferred.
+type deferredMessages struct {
+	pending []outgoingMessage
+}
+
+func (d *deferredMessages) add(m outgoingMessage) {
+	d.pending = append(d.pending, m)
+}
+
+// settle sends the deferred messages in order when the response is a success and drops them
+// otherwise. A failed send is returned and replaces the response.
+func (d *deferredMessages) settle(responseCode int, responseData any, send func(outgoingMessage) error) (int, any, error) {
+	pending := d.pending
+	d.pending = nil
+
+	if _, failed := responseData.(error); failed || responseCode >= http.StatusBadRequest {
+		return responseCode, responseData, nil
+	}
+
+	for _, m := range pending {
+		if err := send(m); err != nil {
+			return http.StatusInternalServerError, err, err
+		}
+	}
+	return responseCode, responseData, nil
+}
@@ -0,0 +1,64 @@
+package ms
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutgoingMessage(t *testing.T) {
+	m := newOutgoingMessage("service.verify", map[string]string{"id": "1"}, []KafkaOption{
+		WithKey("user-1"),
+		WithHeaders(map[string]string{"a": "1"}),
+		WithHeaders(map[string]string{"b": "2"}),
+		Deferred(),
+	})
+
+	assert.Equal(t, "service.verify", m.topic)
+	assert.Equal(t, "user-1", m.key)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, m.headers)
+	assert.True(t, m.deferred)
+	assert.Equal(t, Payload{Body: map[string]string{"id": "1"}}, m.message())
+
+	payload := Payload{Header: Header{Session: "s"}, Body: "x"}
+	assert.Equal(t, payload, newOutgoingMessage("t", payload, nil).message())
+}
+
+func TestDeferredMessagesSettle(t *testing.T) {
+	var sent []string
+	send := func(m outgoingMessage) error {
+		sent = append(sent, m.topic)
+		return nil
+	}
+
+	d := deferredMessages{}
+	d.add(outgoingMessage{topic: "a"})
+	d.add(outgoingMessage{topic: "b"})
+	code, data, err := d.settle(http.StatusCreated, "ok", send)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "ok", data)
+	assert.Equal(t, []string{"a", "b"}, sent)
+
+	// settled messages are not sent twice
+	_, _, err = d.settle(http.StatusOK, nil, send)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, sent)
+
+	sent = nil
+	d.add(outgoingMessage{topic: "a"})
+	d.settle(http.StatusBadRequest, "invalid", send)
+	d.add(outgoingMessage{topic: "b"})
+	d.settle(http.StatusOK, errors.New("boom"), send)
+	assert.Empty(t, sent)
+	assert.Empty(t, d.pending)
+
+	failure := errors.New("broker down")
+	d.add(outgoingMessage{topic: "a"})
+	code, data, err = d.settle(http.StatusOK, "ok", func(outgoingMessage) error { return failure })
+	assert.Equal(t, failure, err)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, failure, data)
+}
@@ -1,6 +1,7 @@
 package ms
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 
@@ -8,12 +9,20 @@ import (
 )
 
 type IContext interface {
+	// Context carries the request deadline, cancellation, session and trace span, and once
+	// CommonLog was called the logs read back by logger.Log. Pass it to the store and clients.
+	Context() context.Context
 	Param(string) string
 	ReadInput() InComing
+	// Bind decodes the input into dst, a pointer to a struct, and validates it; see bind for the tags.
+	// A *ValidationError is returned for invalid input, respond with it to get the standard 400 body.
+	Bind(dst any) error
 	CommonLog(initInvoke, scenario, identity string) (logger.DetailLog, logger.SummaryLog)
 	Response(responseCode int, responseData interface{}) error
 	SendMail(message Message) error
-	SendKafkaMessage(topic string, payload any) error
+	// SendKafkaMessage publishes payload, wrapped in a Payload unless it is one, through the
+	// shared producer and logs it to the context logs.
+	SendKafkaMessage(topic string, payload any, opts ...KafkaOption) error
 }
 
 type ServiceHandleFunc func(c IContext) error
@@ -26,6 +35,8 @@ type HTTPContext struct {
 	intInvoke string
 	scenario  string
 	ms        *application
+	written   bool
+	deferred  deferredMessages
 }
 
 type InComing struct {
@@ -35,13 +46,16 @@ type InComing struct {
 }
 
 type ConsumerContext struct {
-	message kafkaMessage
-	ms      *application
-	l       logger.DetailLog
-	s       logger.SummaryLog
-	topic   string
-	invoke  string
-	payload Msg
+	ctx      context.Context
+	message  kafkaMessage
+	ms       *application
+	l        logger.DetailLog
+	s        logger.SummaryLog
+	topic    string
+	invoke   string
+	payload  Msg
+	session  string
+	deferred deferredMessages
 }
 
 type Header struct {
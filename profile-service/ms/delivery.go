@@ -0,0 +1,107 @@
+package ms
+
+import (
+	"errors"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/sing3demons/profile-service/logger"
+	"github.com/sing3demons/profile-service/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RecordMetadata tells where the broker stored a produced message, logged like the sarama RecordMetadata.
+type RecordMetadata struct {
+	TopicName string `json:"topicName"`
+	Partition int32  `json:"partition"`
+	ErrorCode int    `json:"errorCode"`
+	Offset    int64  `json:"offset,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// Delivery is the future of a message sent with Producer.SendMessageAsync, completed by its delivery report.
+type Delivery struct {
+	producer  *Producer
+	topic     string
+	invoke    string
+	start     time.Time
+	span      trace.Span
+	callbacks []func(RecordMetadata, error)
+
+	detailLog  logger.DetailLog
+	summaryLog logger.SummaryLog
+
+	done     chan struct{}
+	metadata RecordMetadata
+	err      error
+}
+
+func (p *Producer) newDelivery(topic string, span trace.Span, callbacks []func(RecordMetadata, error)) *Delivery {
+	return &Delivery{
+		producer:  p,
+		topic:     topic,
+		invoke:    GenerateXTid("kafka"),
+		span:      span,
+		callbacks: callbacks,
+		done:      make(chan struct{}),
+		metadata:  RecordMetadata{TopicName: topic, Partition: kafka.PartitionAny},
+	}
+}
+
+// Done is closed once the delivery report arrived.
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Wait blocks until the delivery report arrived and returns where the message was stored,
+// or why it could not be delivered.
+func (d *Delivery) Wait() (RecordMetadata, error) {
+	<-d.done
+	return d.metadata, d.err
+}
+
+// complete records the delivery report msg, nil when the message never reached librdkafka.
+func (d *Delivery) complete(msg *kafka.Message, err error) {
+	if msg != nil {
+		d.metadata.Partition = msg.TopicPartition.Partition
+		d.metadata.Offset = int64(msg.TopicPartition.Offset)
+		if !msg.Timestamp.IsZero() {
+			d.metadata.Timestamp = msg.Timestamp.Format(time.RFC3339Nano)
+		}
+	}
+	if err != nil {
+		d.metadata.ErrorCode = -1
+		var kafkaErr kafka.Error
+		if errors.As(err, &kafkaErr) {
+			d.metadata.ErrorCode = int(kafkaErr.Code())
+		}
+	}
+	d.err = err
+
+	d.producer.untrack(d)
+	d.producer.observeDelivery(d.topic, d.start, err)
+	if d.span != nil {
+		tracing.End(d.span, err)
+	}
+
+	if err != nil {
+		if d.detailLog != nil {
+			d.detailLog.AddInputRequest("kafka_producer", d.topic, d.invoke, err.Error(), map[string]interface{}{"error": err.Error(), "metadata": d.metadata})
+		}
+		if d.summaryLog != nil {
+			d.summaryLog.AddErrorBlock("kafka_producer", d.topic, "500", err.Error())
+		}
+	} else {
+		if d.detailLog != nil {
+			d.detailLog.AddInputRequest("kafka_producer", d.topic, d.invoke, nil, d.metadata)
+		}
+		if d.summaryLog != nil {
+			d.summaryLog.AddSuccessBlock("kafka_producer", d.topic, "200", "success")
+		}
+	}
+
+	for _, callback := range d.callbacks {
+		callback(d.metadata, err)
+	}
+	close(d.done)
+}
@@ -0,0 +1,135 @@
+package ms
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sing3demons/profile-service/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProducerDeliveryReports(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+	p := NewProducer(cfg.KafkaCfg.Brokers, app)
+
+	topic := "service.verify"
+	var reported []RecordMetadata
+	callback := func(metadata RecordMetadata, err error) {
+		reported = append(reported, metadata)
+	}
+	delivered := p.newDelivery(topic, nil, []func(RecordMetadata, error){callback})
+	failed := p.newDelivery(topic, nil, []func(RecordMetadata, error){callback})
+
+	events := make(chan kafka.Event, 3)
+	events <- &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 2, Offset: 42},
+		Opaque:         delivered,
+	}
+	events <- kafka.NewError(kafka.ErrAllBrokersDown, "all brokers down", false)
+	events <- &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 1, Error: kafka.NewError(kafka.ErrMsgTimedOut, "timed out", false)},
+		Opaque:         failed,
+	}
+	close(events)
+
+	done := make(chan struct{})
+	p.handleEvents(events, done)
+	<-done
+
+	metadata, err := delivered.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, RecordMetadata{TopicName: topic, Partition: 2, Offset: 42}, metadata)
+
+	metadata, err = failed.Wait()
+	assert.Error(t, err)
+	assert.Equal(t, int(kafka.ErrMsgTimedOut), metadata.ErrorCode)
+	assert.Equal(t, int32(1), metadata.Partition)
+
+	assert.Len(t, reported, 2)
+	assert.Equal(t, 1.0, testutil.ToFloat64(app.metrics.producerSent.WithLabelValues(topic)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(app.metrics.producerFailed.WithLabelValues(topic)))
+}
+
+func TestDeliveryDone(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	d := NewProducer(cfg.KafkaCfg.Brokers, app).newDelivery("t", nil, nil)
+	select {
+	case <-d.Done():
+		t.Fatal("delivery done before its report")
+	default:
+	}
+
+	d.complete(nil, kafka.NewError(kafka.ErrQueueFull, "queue full", false))
+	<-d.Done()
+	_, err := d.Wait()
+	assert.Error(t, err)
+}
+
+type countingSink struct {
+	mu      sync.Mutex
+	entries map[string]int
+}
+
+func (s *countingSink) Write(logType string, entry []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = map[string]int{}
+	}
+	s.entries[logType]++
+}
+
+func (s *countingSink) count(logType string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[logType]
+}
+
+func TestDeliveryCompletesWhileLogsEnd(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+	p := NewProducer(cfg.KafkaCfg.Brokers, app)
+
+	sink := &countingSink{}
+	conf := app.logConfig()
+	conf.Sinks = []logger.Sink{sink}
+	detailLog, summaryLog := logger.NewLogs(httptest.NewRequest("POST", "/register", nil), "invoke", "register", "", conf)
+	detailLog.AddInputRequest("client", "register", "invoke", nil, "body")
+
+	topic := "service.verify"
+	concurrent := p.newDelivery(topic, nil, nil)
+	concurrent.detailLog, concurrent.summaryLog = detailLog, summaryLog
+	late := p.newDelivery(topic, nil, nil)
+	late.detailLog, late.summaryLog = detailLog, summaryLog
+
+	go concurrent.complete(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 1}}, nil)
+	detailLog.AutoEnd()
+	assert.NoError(t, summaryLog.End("200", "success"))
+	concurrent.Wait()
+	written := sink.count(logger.LogTypeDetail)
+
+	// a report landing after the request ended is not logged
+	late.complete(nil, kafka.NewError(kafka.ErrMsgTimedOut, "timed out", false))
+	detailLog.End()
+	assert.Equal(t, written, sink.count(logger.LogTypeDetail))
+	assert.Equal(t, 1, sink.count(logger.LogTypeSummary))
+}
+
+func TestProducerCloseFailsPendingDeliveries(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+	p := NewProducer(cfg.KafkaCfg.Brokers, app)
+
+	d := p.newDelivery("service.verify", nil, nil)
+	p.track(d)
+	assert.NoError(t, p.Close())
+
+	_, err := d.Wait()
+	assert.ErrorIs(t, err, ErrProducerClosed)
+}
@@ -0,0 +1,179 @@
+package ms
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sing3demons/profile-service/middleware"
+	"go.uber.org/zap"
+)
+
+// hiddenDeveloperMessage replaces the cause of the unmapped errors outside the development
+// environments, the cause is in the application log.
+const hiddenDeveloperMessage = "unexpected error, see the service logs"
+
+// AppError is an error a handler can respond with; it carries everything the response
+// envelope and the summary log need.
+type AppError struct {
+	// Code is the stable machine readable error, e.g. `not_found`, logged as the summary result description.
+	Code string
+	// Status is the HTTP status code.
+	Status int
+	// ResultCode is the envelope and summary result code, the status when empty.
+	ResultCode string
+	// Description is the envelope result description, the status text when empty.
+	Description string
+	// DeveloperMessage helps the caller debug, the cause message when empty.
+	DeveloperMessage string
+	// Data is returned as the envelope data, e.g. the field errors of an invalid request.
+	Data  any
+	Cause error
+
+	// unmapped is set for a plain handler error, its cause may hold driver messages.
+	unmapped bool
+}
+
+// NewError returns an AppError answering status with the given code and description.
+func NewError(status int, code, description string) *AppError {
+	return &AppError{Code: code, Status: status, Description: description}
+}
+
+func (e *AppError) Error() string {
+	msg := e.Code
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Cause
+}
+
+// Wrap returns a copy of e caused by err.
+func (e *AppError) Wrap(err error) *AppError {
+	wrapped := *e
+	wrapped.Cause = err
+	return &wrapped
+}
+
+func (e *AppError) resultCode() string {
+	if e.ResultCode != "" {
+		return e.ResultCode
+	}
+	return strconv.Itoa(e.Status)
+}
+
+func (e *AppError) body() middleware.HandlerResponse {
+	desc := e.Description
+	if desc == "" {
+		desc = http.StatusText(e.Status)
+	}
+	developerMessage := e.DeveloperMessage
+	if developerMessage == "" && e.Cause != nil {
+		developerMessage = e.Cause.Error()
+	}
+	return middleware.HandlerResponse{
+		ResultCode:       e.resultCode(),
+		ResultDesc:       desc,
+		DeveloperMessage: developerMessage,
+		Data:             e.Data,
+	}
+}
+
+type errorMapping struct {
+	target error
+	err    *AppError
+}
+
+// MapError makes handlers responding with an error matching target (errors.Is) answer with err,
+// e.g. app.MapError(store.ErrNotFound, ms.NewError(404, "not_found", "resource not found")).
+func (app *application) MapError(target error, err *AppError) {
+	app.errorsMu.Lock()
+	defer app.errorsMu.Unlock()
+	app.errorMappings = append(app.errorMappings, errorMapping{target: target, err: err})
+}
+
+// appError resolves err to an AppError: itself, a validation error, a mapped error, or
+// a plain error answered with status (500 when status is not an error status).
+func (app *application) appError(status int, err error) *AppError {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return &AppError{
+			Code:        validationErr.Message,
+			Status:      http.StatusBadRequest,
+			Description: "invalid request",
+			Data:        validationErr.Errors,
+			Cause:       err,
+		}
+	}
+
+	if app != nil {
+		app.errorsMu.Lock()
+		mappings := app.errorMappings
+		app.errorsMu.Unlock()
+		for _, m := range mappings {
+			if errors.Is(err, m.target) {
+				return m.err.Wrap(err)
+			}
+		}
+	}
+
+	if status < http.StatusBadRequest {
+		status = http.StatusInternalServerError
+	}
+	return &AppError{Code: statusCode(status), Status: status, Cause: err, unmapped: true}
+}
+
+// exposesErrors reports whether the cause of unmapped errors is answered as the developer
+// message, only in the local, dev, development and test environments.
+func (app *application) exposesErrors() bool {
+	if app == nil {
+		return false
+	}
+	switch strings.ToLower(app.config.Env) {
+	case "local", "dev", "development", "test":
+		return true
+	}
+	return false
+}
+
+// statusCode turns a status into a code, e.g. 404 into `not_found`.
+func statusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// envelope builds the response body of responseData and the summary result code and description.
+func (app *application) envelope(status int, responseData any) (int, middleware.HandlerResponse, string, string) {
+	if err, ok := responseData.(error); ok && err != nil {
+		appErr := app.appError(status, err)
+		body := appErr.body()
+		if appErr.unmapped && appErr.DeveloperMessage == "" && !app.exposesErrors() {
+			body.DeveloperMessage = hiddenDeveloperMessage
+			if app != nil && app.logger != nil {
+				app.logger.Error("unmapped handler error", zap.Int("status", appErr.Status), zap.Error(err))
+			}
+		}
+		return appErr.Status, body, appErr.resultCode(), appErr.Code
+	}
+
+	resultCode, resultDesc := strconv.Itoa(status), "success"
+	if status >= http.StatusBadRequest {
+		resultDesc = statusCode(status)
+	}
+	return status, middleware.HandlerResponse{
+		ResultCode: resultCode,
+		ResultDesc: resultDesc,
+		Data:       responseData,
+	}, resultCode, resultDesc
+}
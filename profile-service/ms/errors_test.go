@@ -0,0 +1,189 @@
+package ms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sing3demons/profile-service/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+var errMissing = errors.New("missing")
+
+func TestResponseEnvelope(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+	app.MapError(errMissing, NewError(http.StatusNotFound, "not_found", "resource not found"))
+
+	tests := []struct {
+		name   string
+		status int
+		data   any
+		code   int
+		want   middleware.HandlerResponse
+	}{
+		{
+			name:   "success",
+			status: 200,
+			data:   "ok",
+			code:   200,
+			want:   middleware.HandlerResponse{ResultCode: "200", ResultDesc: "success", Data: "ok"},
+		},
+		{
+			name:   "mapped error",
+			status: 500,
+			data:   fmt.Errorf("get user: %w", errMissing),
+			code:   404,
+			want:   middleware.HandlerResponse{ResultCode: "404", ResultDesc: "resource not found", DeveloperMessage: "get user: missing"},
+		},
+		{
+			name:   "app error",
+			status: 500,
+			data:   &AppError{Code: "locked", Status: 423, ResultCode: "42301", Description: "account locked", DeveloperMessage: "too many attempts"},
+			code:   423,
+			want:   middleware.HandlerResponse{ResultCode: "42301", ResultDesc: "account locked", DeveloperMessage: "too many attempts"},
+		},
+		{
+			name:   "plain error",
+			status: 200,
+			data:   errors.New("boom"),
+			code:   500,
+			want:   middleware.HandlerResponse{ResultCode: "500", ResultDesc: "Internal Server Error", DeveloperMessage: "boom"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/envelope/" + strings.ReplaceAll(tt.name, " ", "-")
+			app.GET(path, func(c IContext) error {
+				return c.Response(tt.status, tt.data)
+			})
+
+			rec := httptest.NewRecorder()
+			app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+			assert.Equal(t, tt.code, rec.Code)
+			var got middleware.HandlerResponse
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	app.DELETE("/envelope", func(c IContext) error {
+		return c.Response(http.StatusNoContent, nil)
+	})
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/envelope", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Body.String())
+}
+
+func TestAppErrorSummaryResult(t *testing.T) {
+	app := &application{}
+	app.MapError(errMissing, NewError(http.StatusNotFound, "not_found", "resource not found"))
+
+	status, _, resultCode, resultDesc := app.envelope(500, errMissing)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "404", resultCode)
+	assert.Equal(t, "not_found", resultDesc)
+
+	appErr := app.appError(500, errMissing)
+	assert.ErrorIs(t, appErr, errMissing)
+
+	status, _, resultCode, resultDesc = app.envelope(400, &ValidationError{Message: "invalid_request"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "400", resultCode)
+	assert.Equal(t, "invalid_request", resultDesc)
+}
+
+func TestHandlerErrorResponds(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+	app.MapError(errMissing, NewError(http.StatusNotFound, "not_found", "resource not found"))
+
+	app.GET("/fail", func(c IContext) error {
+		c.CommonLog(GenerateXTid("test"), "fail", "anonymous")
+		return errMissing
+	})
+	app.GET("/responded", func(c IContext) error {
+		c.Response(http.StatusAccepted, "accepted")
+		return errors.New("after response")
+	})
+
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fail", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	var got middleware.HandlerResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, "404", got.ResultCode)
+	assert.Equal(t, 1.0, testutil.ToFloat64(app.metrics.httpErrors.WithLabelValues(http.MethodGet, "/fail")))
+
+	rec = httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/responded", nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, 1.0, testutil.ToFloat64(app.metrics.httpErrors.WithLabelValues(http.MethodGet, "/responded")))
+}
+
+func TestHandlerWithoutResponse(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	var c *HTTPContext
+	app.GET("/silent", func(ic IContext) error {
+		c = ic.(*HTTPContext)
+		c.CommonLog(GenerateXTid("test"), "silent", "anonymous")
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/silent", nil))
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Nil(t, c.l)
+	assert.True(t, c.s.IsEnd())
+	assert.Equal(t, 0.0, testutil.ToFloat64(app.metrics.httpErrors.WithLabelValues(http.MethodGet, "/silent")))
+}
+
+func TestConsumerHandlerErrorEndsLogs(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	var c *ConsumerContext
+	err := app.runHandler(context.Background(), kafkaMessage{topic: "service.register", value: "{}"}, func(ic IContext) error {
+		c = ic.(*ConsumerContext)
+		c.CommonLog(GenerateXTid("test"), "register", "anonymous")
+		return errMissing
+	})
+
+	assert.ErrorIs(t, err, errMissing)
+	assert.Nil(t, c.l)
+	assert.True(t, c.s.IsEnd())
+	assert.Equal(t, 1.0, testutil.ToFloat64(app.metrics.consumerErrors.WithLabelValues("service.register")))
+}
+
+func TestUnmappedErrorHiddenInProduction(t *testing.T) {
+	c := cfg
+	c.Env = "production"
+	app := NewApplication(c).(*application)
+	t.Cleanup(app.CleanUp)
+	app.MapError(errMissing, NewError(http.StatusNotFound, "not_found", "resource not found"))
+
+	cause := errors.New(`pq: duplicate key value violates unique constraint "profile_email_key"`)
+	status, body, _, _ := app.envelope(http.StatusInternalServerError, fmt.Errorf("create user: %w", cause))
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, hiddenDeveloperMessage, body.DeveloperMessage)
+
+	// the mapped errors and the explicit developer messages are still answered
+	_, body, _, _ = app.envelope(http.StatusInternalServerError, fmt.Errorf("get user: %w", errMissing))
+	assert.Equal(t, "get user: missing", body.DeveloperMessage)
+	_, body, _, _ = app.envelope(http.StatusInternalServerError, &AppError{Status: 409, DeveloperMessage: "email taken", Cause: cause})
+	assert.Equal(t, "email taken", body.DeveloperMessage)
+}
@@ -0,0 +1,197 @@
+package ms
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+const (
+	HealthUp   = "UP"
+	HealthDown = "DOWN"
+)
+
+// HealthCheck reports the availability of a dependency, a nil error means healthy.
+type HealthCheck func(ctx context.Context) error
+
+type HealthStatus struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+type HealthCheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// AddHealthCheck registers a custom readiness check, it runs on every /readyz call.
+func (app *application) AddHealthCheck(name string, check HealthCheck) {
+	app.healthMu.Lock()
+	defer app.healthMu.Unlock()
+	app.healthChecks = append(app.healthChecks, namedHealthCheck{name: name, check: check})
+}
+
+// livenessHandler serves /healthz: the process is up and able to answer HTTP requests.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthStatus{Status: HealthUp})
+}
+
+// readinessHandler serves /readyz: every dependency answered within healthCheckTimeout.
+// It reports DOWN as soon as the shutdown started, so no new traffic is routed here.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		writeHealth(w, http.StatusServiceUnavailable, HealthStatus{Status: HealthDown})
+		return
+	}
+
+	status := app.checkHealth(r.Context())
+	code := http.StatusOK
+	if status.Status != HealthUp {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, status)
+}
+
+func writeHealth(w http.ResponseWriter, code int, status HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// checkHealth runs the dependency checks concurrently.
+func (app *application) checkHealth(ctx context.Context) HealthStatus {
+	checks := app.dependencyChecks()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	status := HealthStatus{Status: HealthUp, Checks: map[string]HealthCheckResult{}}
+
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c namedHealthCheck) {
+			defer wg.Done()
+			result := runHealthCheck(ctx, c.check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			status.Checks[c.name] = result
+			if result.Status != HealthUp {
+				status.Status = HealthDown
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return status
+}
+
+func runHealthCheck(ctx context.Context, check HealthCheck) (result HealthCheckResult) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			result = HealthCheckResult{Status: HealthDown, Error: fmt.Sprint(r)}
+		}
+		result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	}()
+
+	if err := check(ctx); err != nil {
+		return HealthCheckResult{Status: HealthDown, Error: err.Error()}
+	}
+	return HealthCheckResult{Status: HealthUp}
+}
+
+// dependencyChecks returns the built-in checks of the configured dependencies followed by the custom ones.
+func (app *application) dependencyChecks() []namedHealthCheck {
+	var checks []namedHealthCheck
+
+	if app.conn != nil {
+		checks = append(checks, namedHealthCheck{name: "database", check: app.conn.PingContext})
+	}
+
+	if app.config.KafkaCfg.Brokers != "" {
+		checks = append(checks, namedHealthCheck{name: "kafka", check: app.kafkaHealthCheck})
+	}
+
+	if app.config.MailServer.Host != "" {
+		checks = append(checks, namedHealthCheck{name: "smtp", check: func(ctx context.Context) error {
+			return smtpHealthCheck(ctx, app.config.MailServer)
+		}})
+	}
+
+	app.healthMu.Lock()
+	checks = append(checks, app.healthChecks...)
+	app.healthMu.Unlock()
+
+	sort.SliceStable(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+	return checks
+}
+
+// kafkaHealthCheck fetches the cluster metadata through the shared producer connection.
+func (app *application) kafkaHealthCheck(ctx context.Context) error {
+	prod, err := app.sharedProducer().getProducer()
+	if err != nil {
+		return err
+	}
+
+	timeout := healthCheckTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	metadata, err := prod.GetMetadata(nil, false, int(timeout.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	if len(metadata.Brokers) == 0 {
+		return errors.New("no broker available")
+	}
+	return nil
+}
+
+// smtpHealthCheck connects to the mail server and waits for its greeting.
+func smtpHealthCheck(ctx context.Context, server MailServer) error {
+	addr := net.JoinHostPort(server.Host, fmt.Sprint(server.Port))
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if server.Secure {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: server.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, server.Host)
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
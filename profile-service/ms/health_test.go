@@ -0,0 +1,86 @@
+package ms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLivenessHandler(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"UP"}`, rec.Body.String())
+}
+
+func TestReadinessHandler(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	app.AddHealthCheck("cache", func(ctx context.Context) error { return nil })
+
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var status HealthStatus
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, HealthUp, status.Status)
+	assert.Equal(t, HealthUp, status.Checks["cache"].Status)
+
+	app.AddHealthCheck("search", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	rec = httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, HealthDown, status.Status)
+	assert.Equal(t, "connection refused", status.Checks["search"].Error)
+}
+
+func TestReadinessHandlerShuttingDown(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	app.shuttingDown.Store(true)
+
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestShutdownDrainsBeforeClosing(t *testing.T) {
+	c := cfg
+	c.DrainDelay = 200 * time.Millisecond
+	app := NewApplication(c).(*application)
+	t.Cleanup(app.CleanUp)
+
+	srv := httptest.NewServer(app.router)
+	defer srv.Close()
+
+	shutdown := make(chan error)
+	go func() { shutdown <- app.shutdown(srv.Config) }()
+
+	// the listener still answers while readiness is DOWN
+	assert.Eventually(t, func() bool {
+		res, err := http.Get(srv.URL + "/readyz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusServiceUnavailable
+	}, c.DrainDelay, 10*time.Millisecond)
+	assert.NoError(t, <-shutdown)
+}
+
+func TestRunHealthCheckRecoversPanic(t *testing.T) {
+	result := runHealthCheck(context.Background(), func(ctx context.Context) error { panic("boom") })
+	assert.Equal(t, HealthDown, result.Status)
+	assert.Equal(t, "boom", result.Error)
+}
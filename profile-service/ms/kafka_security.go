@@ -0,0 +1,95 @@
+package ms
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// SASL mechanisms accepted by KafkaSASL.Mechanism.
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaSASL authenticates the Kafka clients with a username and password.
+type KafkaSASL struct {
+	// Mechanism is PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, SASL is disabled when empty.
+	Mechanism string
+	Username  string
+	Password  string `secret:"true"`
+}
+
+// KafkaTLS encrypts the connection to the brokers.
+type KafkaTLS struct {
+	Enabled bool
+	// CAFile verifies the broker certificates, the system roots are used when empty.
+	CAFile string
+	// CertFile and KeyFile authenticate the client with a certificate.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify accepts any broker certificate, for development only.
+	InsecureSkipVerify bool
+}
+
+// securityProtocol is the librdkafka security.protocol of the config.
+func (cfg KafkaConfig) securityProtocol() string {
+	switch {
+	case cfg.SASL.Mechanism != "" && cfg.TLS.Enabled:
+		return "sasl_ssl"
+	case cfg.SASL.Mechanism != "":
+		return "sasl_plaintext"
+	case cfg.TLS.Enabled:
+		return "ssl"
+	default:
+		return "plaintext"
+	}
+}
+
+// clientConfig returns the client id and security properties shared by every producer and consumer.
+func (cfg KafkaConfig) clientConfig() (kafka.ConfigMap, error) {
+	config := kafka.ConfigMap{
+		// Protocol used to communicate with brokers.
+		// plaintext, ssl, sasl_plaintext, sasl_ssl
+		"security.protocol": cfg.securityProtocol(),
+	}
+	if cfg.ClientID != "" {
+		config["client.id"] = cfg.ClientID
+	}
+
+	if sasl := cfg.SASL; sasl.Mechanism != "" {
+		mechanism := strings.ToUpper(sasl.Mechanism)
+		switch mechanism {
+		case SASLPlain, SASLScramSHA256, SASLScramSHA512:
+		default:
+			return nil, fmt.Errorf("unsupported SASL mechanism %s", sasl.Mechanism)
+		}
+		if sasl.Username == "" {
+			return nil, errors.New("SASL requires a username")
+		}
+		config["sasl.mechanisms"] = mechanism
+		config["sasl.username"] = sasl.Username
+		config["sasl.password"] = sasl.Password
+	}
+
+	if tls := cfg.TLS; tls.Enabled {
+		if (tls.CertFile == "") != (tls.KeyFile == "") {
+			return nil, errors.New("TLS client authentication requires both a certificate and a key")
+		}
+		if tls.CAFile != "" {
+			config["ssl.ca.location"] = tls.CAFile
+		}
+		if tls.CertFile != "" {
+			config["ssl.certificate.location"] = tls.CertFile
+			config["ssl.key.location"] = tls.KeyFile
+		}
+		if tls.InsecureSkipVerify {
+			config["enable.ssl.certificate.verification"] = false
+			config["ssl.endpoint.identification.algorithm"] = "none"
+		}
+	}
+	return config, nil
+}
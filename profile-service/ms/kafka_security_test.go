@@ -0,0 +1,50 @@
+package ms
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaClientConfig(t *testing.T) {
+	config, err := KafkaConfig{}.clientConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, kafka.ConfigMap{"security.protocol": "plaintext"}, config)
+
+	config, err = KafkaConfig{
+		ClientID: "profile-service",
+		SASL:     KafkaSASL{Mechanism: "scram-sha-512", Username: "svc", Password: "secret"},
+		TLS:      KafkaTLS{Enabled: true, CAFile: "/etc/kafka/ca.pem"},
+	}.clientConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, kafka.ConfigMap{
+		"security.protocol": "sasl_ssl",
+		"client.id":         "profile-service",
+		"sasl.mechanisms":   SASLScramSHA512,
+		"sasl.username":     "svc",
+		"sasl.password":     "secret",
+		"ssl.ca.location":   "/etc/kafka/ca.pem",
+	}, config)
+
+	config, err = KafkaConfig{TLS: KafkaTLS{Enabled: true, CertFile: "client.pem", KeyFile: "client.key", InsecureSkipVerify: true}}.clientConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "ssl", config["security.protocol"])
+	assert.Equal(t, "client.key", config["ssl.key.location"])
+	assert.Equal(t, false, config["enable.ssl.certificate.verification"])
+
+	config, err = KafkaConfig{SASL: KafkaSASL{Mechanism: SASLPlain, Username: "svc"}}.clientConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "sasl_plaintext", config["security.protocol"])
+}
+
+func TestKafkaClientConfigInvalid(t *testing.T) {
+	for name, cfg := range map[string]KafkaConfig{
+		"mechanism": {SASL: KafkaSASL{Mechanism: "GSSAPI", Username: "svc"}},
+		"username":  {SASL: KafkaSASL{Mechanism: SASLPlain}},
+		"key":       {TLS: KafkaTLS{Enabled: true, CertFile: "client.pem"}},
+	} {
+		_, err := cfg.clientConfig()
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,89 @@
+package ms
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sing3demons/profile-service/logger"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogSettings is the body of /admin/logs: GET returns the current settings, PUT changes the
+// sections it holds, e.g. {"level":"debug"} or {"detail":{"rawData":true,"sampling":[...]}}.
+type LogSettings struct {
+	Level  string                 `json:"level,omitempty"`
+	Detail *logger.PolicySettings `json:"detail,omitempty"`
+}
+
+// policySettings are the startup values of the settings changed at /admin/logs.
+func (c DetailLogConfig) policySettings() logger.PolicySettings {
+	return logger.PolicySettings{
+		RawData:      c.RawData,
+		RawDataLimit: c.RawDataLimit,
+		Sampling:     c.Sampling,
+	}
+}
+
+// adminOnly serves the requests bearing LogConfig.AdminToken and answers 401 to the others.
+func (app *application) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.config.LogConfig.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logSettingsHandler serves /admin/logs, the changes apply to the requests started after.
+func (app *application) logSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var settings LogSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := app.updateLogSettings(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		app.Log("ADMIN", "log settings changed: "+logger.ToJson(app.logSettings()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(app.logSettings())
+}
+
+func (app *application) logSettings() LogSettings {
+	detail := app.logPolicy.Settings()
+	return LogSettings{
+		Level:  app.config.LogConfig.AppLog.AtomicLevel.String(),
+		Detail: &detail,
+	}
+}
+
+// updateLogSettings validates every section before changing any.
+func (app *application) updateLogSettings(settings LogSettings) error {
+	var level zapcore.Level
+	if settings.Level != "" {
+		l, err := zapcore.ParseLevel(settings.Level)
+		if err != nil {
+			return err
+		}
+		level = l
+	}
+	if settings.Detail != nil {
+		if err := app.logPolicy.Update(*settings.Detail); err != nil {
+			return err
+		}
+	}
+	if settings.Level != "" {
+		app.config.LogConfig.AppLog.AtomicLevel.SetLevel(level)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package ms
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sing3demons/profile-service/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogSettingsEndpoint(t *testing.T) {
+	c := cfg
+	c.LogConfig.AdminToken = "admin-secret"
+	c.LogConfig.Detail.Sampling = []logger.SamplingRule{{Scenario: "*", Rate: 0.5}}
+	app := NewApplication(c).(*application)
+	t.Cleanup(app.CleanUp)
+
+	call := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/logs", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		app.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "wrong", "").Code)
+
+	rec := call(http.MethodGet, "admin-secret", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var settings LogSettings
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &settings))
+	assert.Equal(t, "info", settings.Level)
+	assert.Equal(t, []logger.SamplingRule{{Scenario: "*", Rate: 0.5}}, settings.Detail.Sampling)
+
+	rec = call(http.MethodPut, "admin-secret", `{"level":"debug","detail":{"rawData":true,"rawDataLimit":1024}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, app.logger.Core().Enabled(zapcore.DebugLevel))
+	assert.Equal(t, logger.PolicySettings{RawData: true, RawDataLimit: 1024, Sampling: []logger.SamplingRule{}}, app.logPolicy.Settings())
+
+	// nothing is changed when a section is invalid
+	rec = call(http.MethodPut, "admin-secret", `{"level":"warn","detail":{"sampling":[{"scenario":"*","rate":2}]}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "debug", app.config.LogConfig.AppLog.AtomicLevel.String())
+	assert.Equal(t, 1024, app.logPolicy.Settings().RawDataLimit)
+}
+
+func TestLogSettingsEndpointDisabled(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/logs", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,205 @@
+package ms
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Intervals of LogRotation.Interval.
+const (
+	RotateHourly = "hourly"
+	RotateDaily  = "daily"
+)
+
+// backupTimeFormat is the time lumberjack puts in the rotated file names.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// pruneInterval is how often the disk budget of the log files is checked.
+var pruneInterval = time.Minute
+
+// LogRotation rotates a log file by size and time and prunes the rotated files. The file
+// keeps its <service name>.log name, the rotated files are <service name>-<time>.log and
+// .log.gz once compressed.
+type LogRotation struct {
+	// MaxSizeMB rotates the file before it grows past this size, default 100.
+	MaxSizeMB int `json:"maxSizeMB"`
+	// Interval also rotates the file every hour or day: hourly, daily or empty for none.
+	Interval string `json:"interval"`
+	// MaxBackups and MaxAgeDays prune the rotated files, no limit when zero.
+	MaxBackups int `json:"maxBackups"`
+	MaxAgeDays int `json:"maxAgeDays"`
+	// MaxTotalSizeMB deletes the oldest rotated files once the file and its rotated files
+	// take more disk, no limit when zero.
+	MaxTotalSizeMB int  `json:"maxTotalSizeMB"`
+	Compress       bool `json:"compress"`
+}
+
+// orDefault returns r, or def when r is not set.
+func (r LogRotation) orDefault(def LogRotation) LogRotation {
+	if r == (LogRotation{}) {
+		return def
+	}
+	return r
+}
+
+func (r LogRotation) validate() error {
+	var errs []error
+	if r.MaxSizeMB < 0 || r.MaxBackups < 0 || r.MaxAgeDays < 0 || r.MaxTotalSizeMB < 0 {
+		errs = append(errs, errors.New("sizes, backups and ages must not be negative"))
+	}
+	if r.Interval != "" && !strings.EqualFold(r.Interval, RotateHourly) && !strings.EqualFold(r.Interval, RotateDaily) {
+		errs = append(errs, fmt.Errorf("interval %q is neither %s nor %s", r.Interval, RotateHourly, RotateDaily))
+	}
+	return errors.Join(errs...)
+}
+
+// logFile is a log file rotated by size with lumberjack, and by time and pruned to its disk
+// budget in the background. Close reopens the file on the next write.
+type logFile struct {
+	*lumberjack.Logger
+	interval string
+	budget   int64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newLogFile(dir, service string, rotation LogRotation) *logFile {
+	if service == "" {
+		service = "go-service"
+	}
+	f := &logFile{
+		Logger: &lumberjack.Logger{
+			Filename:   filepath.Join(dir, service+".log"),
+			MaxSize:    rotation.MaxSizeMB,
+			MaxBackups: rotation.MaxBackups,
+			MaxAge:     rotation.MaxAgeDays,
+			LocalTime:  true,
+			Compress:   rotation.Compress,
+		},
+		interval: strings.ToLower(rotation.Interval),
+		budget:   int64(rotation.MaxTotalSizeMB) * 1024 * 1024,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if f.interval == "" && f.budget == 0 {
+		close(f.done)
+		return f
+	}
+	go f.run()
+	return f
+}
+
+func (f *logFile) run() {
+	defer close(f.done)
+
+	var rotate *time.Timer
+	var rotateC, pruneC <-chan time.Time
+	if f.interval != "" {
+		rotate = time.NewTimer(time.Until(nextRotation(time.Now(), f.interval)))
+		defer rotate.Stop()
+		rotateC = rotate.C
+	}
+	if f.budget > 0 {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		pruneC = ticker.C
+	}
+	for {
+		select {
+		case <-rotateC:
+			f.rotateIfWritten()
+			f.prune()
+			rotate.Reset(time.Until(nextRotation(time.Now(), f.interval)))
+		case <-pruneC:
+			f.prune()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// rotateIfWritten rotates the file unless it is empty, a quiet service keeps no empty files.
+func (f *logFile) rotateIfWritten() {
+	if info, err := os.Stat(f.Filename); err == nil && info.Size() > 0 {
+		f.Rotate()
+	}
+}
+
+// prune deletes the oldest rotated files until the file and its rotated files fit the budget.
+func (f *logFile) prune() {
+	if f.budget <= 0 {
+		return
+	}
+	dir := filepath.Dir(f.Filename)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	ext := filepath.Ext(f.Filename)
+	prefix := strings.TrimSuffix(filepath.Base(f.Filename), ext) + "-"
+	type backup struct {
+		name string
+		at   time.Time
+		size int64
+	}
+	var backups []backup
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if e.Name() == filepath.Base(f.Filename) {
+			total += info.Size()
+			continue
+		}
+		stamp, ok := strings.CutPrefix(strings.TrimSuffix(e.Name(), ".gz"), prefix)
+		if !ok || !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		at, err := time.Parse(backupTimeFormat, strings.TrimSuffix(stamp, ext))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{name: e.Name(), at: at, size: info.Size()})
+		total += info.Size()
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].at.Before(backups[j].at) })
+	for _, b := range backups {
+		if total <= f.budget {
+			return
+		}
+		if err := os.Remove(filepath.Join(dir, b.name)); err == nil {
+			total -= b.size
+		}
+	}
+}
+
+// shutdown stops the background rotation and closes the file for good.
+func (f *logFile) shutdown() error {
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+	<-f.done
+	return f.Close()
+}
+
+// nextRotation returns the start of the hour or day after now, in the local time of now.
+func nextRotation(now time.Time, interval string) time.Time {
+	year, month, day := now.Date()
+	if interval == RotateHourly {
+		return time.Date(year, month, day, now.Hour()+1, 0, 0, 0, now.Location())
+	}
+	return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+}
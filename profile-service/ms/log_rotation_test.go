@@ -0,0 +1,92 @@
+package ms
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextRotation(t *testing.T) {
+	now := time.Date(2024, 12, 31, 23, 15, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), nextRotation(now, RotateHourly))
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), nextRotation(now, RotateDaily))
+
+	now = time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC), nextRotation(now, RotateHourly))
+	assert.Equal(t, time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC), nextRotation(now, RotateDaily))
+}
+
+func TestLogFilePrunesToBudget(t *testing.T) {
+	dir := t.TempDir()
+	chunk := make([]byte, 400*1024)
+	for _, name := range []string{
+		"profile-service.log",
+		"profile-service-2024-06-01T09-00-00.000.log.gz",
+		"profile-service-2024-06-01T10-00-00.000.log.gz",
+		"profile-service-2024-06-01T11-00-00.000.log",
+		"other-2024-06-01T08-00-00.000.log",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), chunk, 0o644))
+	}
+
+	f := newLogFile(dir, "profile-service", LogRotation{MaxTotalSizeMB: 1})
+	f.prune()
+	assert.NoError(t, f.shutdown())
+
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{
+		"profile-service.log",
+		"profile-service-2024-06-01T11-00-00.000.log",
+		"other-2024-06-01T08-00-00.000.log",
+	}, names)
+}
+
+func TestLogFilesReopenOnHangup(t *testing.T) {
+	dir := t.TempDir()
+	c := cfg
+	c.LogConfig.AppLog.LogFile = true
+	c.LogConfig.AppLog.Name = filepath.Join(dir, "app")
+	c.LogConfig.Summary.LogFile = true
+	c.LogConfig.Summary.Name = filepath.Join(dir, "summary")
+	c.LogConfig.Summary.Rotation = LogRotation{Interval: RotateDaily, MaxTotalSizeMB: 100}
+	app := NewApplication(c).(*application)
+	t.Cleanup(app.CleanUp)
+
+	summary := filepath.Join(dir, "summary", "profile-service.log")
+	app.logger.Info("started")
+	app.config.LogConfig.Summary.LogSummary.Info("before")
+	assert.FileExists(t, summary)
+	assert.FileExists(t, filepath.Join(dir, "app", "profile-service.log"))
+
+	// logrotate moves the file then signals the service
+	assert.NoError(t, os.Rename(summary, summary+".1"))
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Eventually(t, func() bool {
+		app.config.LogConfig.Summary.LogSummary.Info("after")
+		_, err := os.Stat(summary)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	moved, _ := os.ReadFile(summary + ".1")
+	assert.Contains(t, string(moved), "before")
+}
+
+func TestConfigValidateLogRotation(t *testing.T) {
+	c := cfg
+	c.LogConfig.Detail.Rotation = LogRotation{Interval: "Hourly", MaxTotalSizeMB: 1024}
+	assert.NoError(t, c.Validate())
+
+	c.LogConfig.Detail.Rotation.Interval = "weekly"
+	c.LogConfig.Summary.Rotation.MaxBackups = -1
+	err := c.Validate()
+	assert.ErrorContains(t, err, "log.detail.rotation:")
+	assert.ErrorContains(t, err, "log.summary.rotation:")
+}
@@ -0,0 +1,211 @@
+package ms
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Policies of KafkaLogConfig.Policy when the buffer is full.
+const (
+	LogSinkDrop  = "drop"
+	LogSinkBlock = "block"
+)
+
+// KafkaLogConfig ships the detail and summary logs to the topic consumed by logstash.
+type KafkaLogConfig struct {
+	Enabled bool
+	// Topic is the topic logstash consumes, default write-logs.
+	Topic string
+	// Index is the elasticsearch index prefix set as [@metadata][index], suffixed with the
+	// log type, e.g. profile-service-detail. Default is the service name.
+	Index string
+	// BufferSize bounds the logs waiting to be sent, default 10000.
+	BufferSize int
+	// BatchSize and FlushInterval bound a batch sent to Kafka, default 500 logs and 1s.
+	BatchSize     int
+	FlushInterval time.Duration
+	// Policy is drop (default) to lose the logs of a full buffer or block to wait for room.
+	Policy string
+}
+
+// kafkaLogSink is a logger.Sink sending the logs from a bounded buffer in batches.
+type kafkaLogSink struct {
+	topic    string
+	index    string
+	block    bool
+	size     int
+	interval time.Duration
+	metrics  *metrics
+	// publish sends a batch and returns the delivery error of each value.
+	publish func(topic string, values [][]byte) []error
+
+	mu      sync.RWMutex
+	closed  bool
+	records chan logRecord
+	done    chan struct{}
+}
+
+type logRecord struct {
+	logType string
+	entry   []byte
+}
+
+func newKafkaLogSink(cfg KafkaLogConfig, service string, metrics *metrics, publish func(topic string, values [][]byte) []error) *kafkaLogSink {
+	if cfg.Topic == "" {
+		cfg.Topic = "write-logs"
+	}
+	if cfg.Index == "" {
+		cfg.Index = service
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 10000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+
+	s := &kafkaLogSink{
+		topic:    cfg.Topic,
+		index:    strings.ToLower(cfg.Index),
+		block:    strings.EqualFold(cfg.Policy, LogSinkBlock),
+		size:     cfg.BatchSize,
+		interval: cfg.FlushInterval,
+		metrics:  metrics,
+		publish:  publish,
+		records:  make(chan logRecord, cfg.BufferSize),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write buffers entry, it is dropped when the buffer is full unless the policy is block.
+func (s *kafkaLogSink) Write(logType string, entry []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.metrics.logSinkDropped.WithLabelValues(logType, "closed").Inc()
+		return
+	}
+
+	record := logRecord{logType: logType, entry: entry}
+	if s.block {
+		s.records <- record
+		return
+	}
+	select {
+	case s.records <- record:
+	default:
+		s.metrics.logSinkDropped.WithLabelValues(logType, "buffer_full").Inc()
+	}
+}
+
+func (s *kafkaLogSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	batch := make([]logRecord, 0, s.size)
+	flush := func() {
+		if len(batch) > 0 {
+			s.send(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case record, ok := <-s.records:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= s.size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *kafkaLogSink) send(batch []logRecord) {
+	logTypes := make([]string, 0, len(batch))
+	values := make([][]byte, 0, len(batch))
+	for _, record := range batch {
+		value, err := withIndex(record.entry, s.index+"-"+record.logType)
+		if err != nil {
+			s.metrics.logSinkDropped.WithLabelValues(record.logType, "invalid").Inc()
+			continue
+		}
+		logTypes = append(logTypes, record.logType)
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	for i, err := range s.publish(s.topic, values) {
+		if err != nil {
+			s.metrics.logSinkDropped.WithLabelValues(logTypes[i], "delivery_failed").Inc()
+			continue
+		}
+		s.metrics.logSinkSent.WithLabelValues(logTypes[i]).Inc()
+	}
+}
+
+// Close sends the buffered logs, the logs written after are dropped.
+func (s *kafkaLogSink) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.records)
+	s.mu.Unlock()
+
+	<-s.done
+}
+
+// withIndex adds the [@metadata][index] logstash uses to pick the elasticsearch index.
+func withIndex(entry []byte, index string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(entry, &fields); err != nil {
+		return nil, err
+	}
+	metadata, err := json.Marshal(map[string]string{"index": index})
+	if err != nil {
+		return nil, err
+	}
+	fields["@metadata"] = metadata
+	return json.Marshal(fields)
+}
+
+// publishLogs sends values with the shared producer and waits for their delivery reports.
+func (app *application) publishLogs(topic string, values [][]byte) []error {
+	producer := app.sharedProducer()
+	deliveries := make([]*Delivery, 0, len(values))
+	for _, value := range values {
+		d := producer.newDelivery(topic, nil, nil)
+		producer.enqueue(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+			Value:          value,
+		}, d)
+		deliveries = append(deliveries, d)
+	}
+
+	errs := make([]error, len(deliveries))
+	for i, d := range deliveries {
+		_, errs[i] = d.Wait()
+	}
+	return errs
+}
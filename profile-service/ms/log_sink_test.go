@@ -0,0 +1,96 @@
+package ms
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sing3demons/profile-service/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type publishedLogs struct {
+	mu     sync.Mutex
+	topic  string
+	values []map[string]any
+	fail   bool
+}
+
+func (p *publishedLogs) publish(topic string, values [][]byte) []error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.topic = topic
+	errs := make([]error, len(values))
+	for i, value := range values {
+		if p.fail {
+			errs[i] = errors.New("broker down")
+			continue
+		}
+		var v map[string]any
+		json.Unmarshal(value, &v)
+		p.values = append(p.values, v)
+	}
+	return errs
+}
+
+func TestKafkaLogSinkTagsIndex(t *testing.T) {
+	metrics := NewMetrics("profile-service")
+	published := &publishedLogs{}
+	sink := newKafkaLogSink(KafkaLogConfig{FlushInterval: time.Hour}, "Profile-Service", metrics, published.publish)
+
+	conf := logger.LogConfig{ProjectName: "profile-service", Sinks: []logger.Sink{sink}}
+	req := httptest.NewRequest("POST", "/register", nil)
+	detail := logger.NewDetailLog(req, "invoke", "register", "", conf)
+	detail.AddInputRequest("client", "register", "invoke", nil, map[string]any{"password": "p4ss"})
+	detail.End()
+	assert.NoError(t, logger.NewSummaryLog(req, "invoke", "register", conf).End("20000", "success"))
+
+	sink.Close()
+	assert.Equal(t, "write-logs", published.topic)
+	if assert.Len(t, published.values, 2) {
+		assert.Equal(t, map[string]any{"index": "profile-service-detail"}, published.values[0]["@metadata"])
+		assert.Equal(t, "Detail", published.values[0]["LogType"])
+		assert.NotContains(t, logger.ToJson(published.values[0]), "p4ss")
+		assert.Equal(t, map[string]any{"index": "profile-service-summary"}, published.values[1]["@metadata"])
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.logSinkSent.WithLabelValues(logger.LogTypeDetail)))
+
+	sink.Write(logger.LogTypeDetail, []byte(`{}`))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.logSinkDropped.WithLabelValues(logger.LogTypeDetail, "closed")))
+}
+
+func TestKafkaLogSinkDropsWhenFull(t *testing.T) {
+	metrics := NewMetrics("profile-service")
+	release := make(chan struct{})
+	published := &publishedLogs{fail: true}
+	sink := newKafkaLogSink(KafkaLogConfig{BufferSize: 1, BatchSize: 1}, "profile-service", metrics,
+		func(topic string, values [][]byte) []error {
+			<-release
+			return published.publish(topic, values)
+		})
+
+	// the first log is held by publish, the second fills the buffer
+	sink.Write(logger.LogTypeSummary, []byte(`{"n":1}`))
+	assert.Eventually(t, func() bool { return len(sink.records) == 0 }, time.Second, time.Millisecond)
+	sink.Write(logger.LogTypeSummary, []byte(`{"n":2}`))
+	sink.Write(logger.LogTypeSummary, []byte(`{"n":3}`))
+	sink.Write(logger.LogTypeSummary, []byte(`not json`))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.logSinkDropped.WithLabelValues(logger.LogTypeSummary, "buffer_full")))
+
+	close(release)
+	sink.Close()
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.logSinkDropped.WithLabelValues(logger.LogTypeSummary, "delivery_failed")))
+}
+
+func TestConfigValidateLogSinkPolicy(t *testing.T) {
+	c := cfg
+	c.LogConfig.Kafka.Policy = "Block"
+	assert.NoError(t, c.Validate())
+
+	c.LogConfig.Kafka.Policy = "retry"
+	assert.ErrorContains(t, c.Validate(), "log.kafka.policy:")
+}
@@ -1,67 +1,48 @@
 package ms
 
 import (
-	"fmt"
+	"io"
 	"os"
-	"path/filepath"
-	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// Ensure the /log/detail directory exists
-
-// Function to generate log file name based on appName and timestamp (pattern: appName_YYYYMMDD_HHmmss.log)
-func getLogFileName(t time.Time) string {
-	appName := os.Getenv("SERVICE_NAME")
-	if appName == "" {
-		appName = "go-service"
-	}
-	year, month, day := t.Date()
-	hour, minute, second := t.Clock()
-
-	return fmt.Sprintf("%s_%04d%02d%02d_%02d%02d%02d.log", appName, year, month, day, hour, minute, second)
-}
+// Rotations of the log files when LogRotation is not set.
+var (
+	defaultAppLogRotation = LogRotation{MaxSizeMB: 10, MaxBackups: 3, MaxAgeDays: 7}
+	defaultLogRotation    = LogRotation{MaxSizeMB: 500, MaxBackups: 3, MaxAgeDays: 1, Compress: true}
+)
 
-func NewLogger(wf AppLog) *zap.Logger {
+// NewLogger returns the application logger writing to stdout, and as JSON to file too when
+// it is not nil. Its level follows wf.AtomicLevel, info when unset.
+func NewLogger(wf AppLog, file io.Writer) *zap.Logger {
 	// create a zapcore encoder config
 	var encCfg zapcore.EncoderConfig
 	encCfg.MessageKey = "msg"
 
-	core := zapcore.NewCore(zapcore.NewConsoleEncoder(encCfg), zapcore.AddSync(os.Stdout), zap.InfoLevel)
+	level := wf.AtomicLevel
+	if level == (zap.AtomicLevel{}) {
+		level = zap.NewAtomicLevelAt(zap.InfoLevel)
+	}
 
-	if wf.LogFile {
-		logFile := filepath.Join(wf.Name, getLogFileName(time.Now()))
-		if _, err := os.Stat(wf.Name); os.IsNotExist(err) {
-			err := os.MkdirAll(wf.Name, os.ModePerm)
-			if err != nil {
-				panic("failed to create log directory")
-			}
-		}
+	core := zapcore.NewCore(zapcore.NewConsoleEncoder(encCfg), zapcore.AddSync(os.Stdout), level)
 
-		w := zapcore.NewMultiWriteSyncer(zapcore.AddSync(&lumberjack.Logger{
-			Filename:   logFile,
-			MaxSize:    10, // megabytes
-			MaxBackups: 3,
-			MaxAge:     7,
-		}), zapcore.AddSync(os.Stdout))
+	if file != nil {
+		w := zapcore.NewMultiWriteSyncer(zapcore.AddSync(file), zapcore.AddSync(os.Stdout))
 
 		core = zapcore.NewCore(
 			zapcore.NewJSONEncoder(encCfg),
 			w,
-			zap.InfoLevel,
+			level,
 		)
 	}
 
 	return zap.New(core)
 }
 
-func createLogger(path string) (*zap.Logger, error) {
-	// Create log file with rotating mechanism
-	logFile := filepath.Join(path, getLogFileName(time.Now()))
-
+// NewLogFile returns the logger writing the detail or summary logs to file.
+func NewLogFile(file io.Writer) *zap.Logger {
 	// Create a zapcore encoder config
 	encCfg := zapcore.EncoderConfig{
 		MessageKey:   "msg",
@@ -74,29 +55,8 @@ func createLogger(path string) (*zap.Logger, error) {
 	// File encoder using console format
 	fileEncoder := zapcore.NewConsoleEncoder(encCfg)
 
-	// Setting up lumberjack logger for log rotation
-	writerSync := zapcore.AddSync(&lumberjack.Logger{
-		Filename:   logFile,
-		MaxSize:    500, // megabytes
-		MaxBackups: 3,   // number of backups
-		MaxAge:     1,   // days
-		LocalTime:  true,
-		Compress:   true, // compress the backups
-	})
-
 	// Create the core with InfoLevel logging
-	core := zapcore.NewCore(fileEncoder, writerSync, zap.InfoLevel)
-
-	// Create logger
-	log := zap.New(core)
-
-	return log, nil
-}
+	core := zapcore.NewCore(fileEncoder, zapcore.AddSync(file), zap.InfoLevel)
 
-func NewLogFile(path string) *zap.Logger {
-	log, err := createLogger(path)
-	if err != nil {
-		fmt.Println("Failed to create log file logger:", err)
-	}
-	return log
+	return zap.New(core)
 }
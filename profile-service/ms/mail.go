@@ -1,10 +1,17 @@
 package ms
 
 import (
+	"context"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/sing3demons/profile-service/constants"
 	"github.com/sing3demons/profile-service/logger"
+	"github.com/sing3demons/profile-service/tracing"
+	"github.com/sing3demons/profile-service/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	gomail "gopkg.in/mail.v2"
 )
 
@@ -19,12 +26,12 @@ type MailServer struct {
 
 type Auth struct {
 	User string `json:"username,omitempty"`
-	Pass string `json:"password,omitempty"`
+	Pass string `json:"password,omitempty" secret:"true"`
 }
 
 type Message struct {
 	From        string   `json:"from,omitempty"`
-	To          string   `json:"to,omitempty"`
+	To          string   `json:"to,omitempty" validate:"required"`
 	Subject     string   `json:"subject,omitempty"`
 	Body        string   `json:"body,omitempty"`
 	Attachment  string   `json:"attachment,omitempty"`
@@ -37,7 +44,31 @@ type Result struct {
 	ResultData interface{}
 }
 
-func sendMail(mailServer MailServer, params Message, detailLog logger.DetailLog, summaryLog logger.SummaryLog) Result {
+// sendMail sends params through the configured mail server and records the outcome metrics.
+func (app *application) sendMail(ctx context.Context, params Message) Result {
+	ctx, span := tracing.Start(ctx, "smtp send", trace.SpanKindClient,
+		attribute.String("server.address", app.config.MailServer.Host),
+		attribute.Int("server.port", app.config.MailServer.Port),
+	)
+
+	start := time.Now()
+	result := sendMail(ctx, app.config.MailServer, params)
+
+	status := "success"
+	if result.Err {
+		status = result.ResultDesc
+		tracing.End(span, fmt.Errorf("%s: %v", result.ResultDesc, result.ResultData))
+	} else {
+		span.End()
+	}
+	app.metrics.mailSent.WithLabelValues(status).Inc()
+	app.metrics.mailDuration.Observe(time.Since(start).Seconds())
+	return result
+}
+
+// sendMail logs to the logs of ctx.
+func sendMail(ctx context.Context, mailServer MailServer, params Message) Result {
+	detailLog, summaryLog := logger.Log(ctx)
 	cmdName := "send_mail"
 	result := Result{}
 	invoke := GenerateXTid(cmdName)
@@ -55,7 +86,10 @@ func sendMail(mailServer MailServer, params Message, detailLog logger.DetailLog,
 	}
 	dialer.SSL = mailServer.Secure
 
-	detailLog.AddOutputRequest(constants.MAIL_SERVER, cmdName, invoke, params, params)
+	// the body can hold one-time links, only its size is logged
+	logged := params
+	logged.Body = fmt.Sprintf("<%d bytes>", len(params.Body))
+	detailLog.AddOutputRequest(constants.MAIL_SERVER, cmdName, invoke, logged, logged)
 	detailLog.End()
 
 	// Create the email message
@@ -65,6 +99,9 @@ func sendMail(mailServer MailServer, params Message, detailLog logger.DetailLog,
 	message.SetHeader("Subject", params.Subject)
 	message.SetBody("text/html", params.Body)
 	message.SetHeader("Return-Path", params.From)
+	if session := utils.SessionFromContext(ctx); session != "" {
+		message.SetHeader(constants.SessionHeader, session)
+	}
 
 	if params.Attachment != "" {
 		message.Attach(params.Attachment)
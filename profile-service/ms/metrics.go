@@ -1,38 +1,206 @@
 package ms
 
 import (
+	"database/sql"
 	"net/http"
+	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+var metricNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
 // metrics represents Prometheus metrics.
 type metrics struct {
-	// A metric to record the duration of requests,
-	// such as database queries or requests to the S3 object store.
-	duration *prometheus.SummaryVec
+	namespace string
+	registry  *prometheus.Registry
+
+	// HTTP server, labelled by route template
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	httpInFlight prometheus.Gauge
+	httpErrors   *prometheus.CounterVec
+
+	// Kafka consumer, labelled by topic
+	consumerMessages *prometheus.CounterVec
+	consumerDuration *prometheus.HistogramVec
+	consumerErrors   *prometheus.CounterVec
+	consumerDropped  *prometheus.CounterVec
+	consumerLag      *prometheus.GaugeVec
+
+	// Kafka producer, labelled by topic
+	producerSent     *prometheus.CounterVec
+	producerFailed   *prometheus.CounterVec
+	producerDelivery *prometheus.HistogramVec
+
+	// SMTP, labelled by result
+	mailSent     *prometheus.CounterVec
+	mailDuration prometheus.Histogram
+
+	outboxPending   prometheus.Gauge
+	outboxPublished prometheus.Counter
+	outboxFailed    prometheus.Counter
+	outboxParked    prometheus.Counter
+
+	// Kafka log sink, labelled by log type
+	logSinkSent    *prometheus.CounterVec
+	logSinkDropped *prometheus.CounterVec
+}
+
+// metricNamespace turns the service name into a valid Prometheus namespace, e.g. profile-service -> profile_service.
+func metricNamespace(name string) string {
+	return metricNameRegex.ReplaceAllString(name, "_")
 }
 
 // Create new metrics and register them with the Prometheus registry.
-func NewMetrics(reg prometheus.Registerer) *metrics {
+func NewMetrics(namespace string) *metrics {
+	namespace = metricNamespace(namespace)
+
 	// Create Prometheus metrics.
 	m := &metrics{
-		duration: prometheus.NewSummaryVec(prometheus.SummaryOpts{
-			Namespace:  "myapp",
-			Name:       "request_duration_seconds",
-			Help:       "Duration of the request.",
-			Objectives: map[float64]float64{0.9: 0.01, 0.99: 0.001},
-		}, []string{"op"}),
+		namespace: namespace,
+		registry:  prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		httpInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Number of HTTP requests being served.",
+		}),
+		httpErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_handler_errors_total",
+			Help:      "Number of HTTP handler calls that returned an error.",
+		}, []string{"method", "route"}),
+
+		consumerMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kafka_consumer_messages_total",
+			Help:      "Number of Kafka messages consumed.",
+		}, []string{"topic"}),
+		consumerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "kafka_consumer_handler_duration_seconds",
+			Help:      "Duration of the Kafka message handlers, retries included.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"topic"}),
+		consumerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kafka_consumer_errors_total",
+			Help:      "Number of Kafka handler calls that returned an error.",
+		}, []string{"topic"}),
+		consumerDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kafka_consumer_dropped_total",
+			Help:      "Number of failed Kafka messages skipped because their topic has no retry policy.",
+		}, []string{"topic"}),
+		consumerLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "kafka_consumer_lag",
+			Help:      "Number of messages between the last consumed offset and the partition high watermark.",
+		}, []string{"topic", "partition"}),
+
+		producerSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kafka_producer_messages_total",
+			Help:      "Number of Kafka messages acknowledged by the broker.",
+		}, []string{"topic"}),
+		producerFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kafka_producer_failed_total",
+			Help:      "Number of Kafka messages that could not be delivered.",
+		}, []string{"topic"}),
+		producerDelivery: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "kafka_producer_delivery_seconds",
+			Help:      "Time between producing a message and its delivery report.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"topic"}),
+
+		mailSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "smtp_mails_total",
+			Help:      "Number of mails sent by result (success, timeout, connection_error).",
+		}, []string{"result"}),
+		mailDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "smtp_send_duration_seconds",
+			Help:      "Duration of the SMTP send.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+
+		outboxPending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "outbox_pending_messages",
+			Help:      "Number of outbox messages waiting to be published.",
+		}),
+		outboxPublished: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "outbox_published_total",
+			Help:      "Number of outbox messages published to Kafka.",
+		}),
+		outboxFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "outbox_failed_total",
+			Help:      "Number of outbox publish attempts that failed.",
+		}),
+		outboxParked: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "outbox_parked_total",
+			Help:      "Number of outbox messages parked after OutboxConfig.MaxAttempts failed publishes.",
+		}),
+
+		logSinkSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_sink_sent_total",
+			Help:      "Number of detail and summary logs delivered to the Kafka log topic.",
+		}, []string{"log_type"}),
+		logSinkDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_sink_dropped_total",
+			Help:      "Number of detail and summary logs lost by reason (buffer_full, delivery_failed, invalid, closed).",
+		}, []string{"log_type", "reason"}),
 	}
+
 	// Register metrics with Prometheus registry.
-	reg.MustRegister(m.duration)
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{Namespace: namespace}),
+		m.httpRequests, m.httpDuration, m.httpInFlight, m.httpErrors,
+		m.consumerMessages, m.consumerDuration, m.consumerErrors, m.consumerDropped, m.consumerLag,
+		m.producerSent, m.producerFailed, m.producerDelivery,
+		m.mailSent, m.mailDuration,
+		m.outboxPending, m.outboxPublished, m.outboxFailed, m.outboxParked,
+		m.logSinkSent, m.logSinkDropped,
+	)
 
 	return m
 }
 
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// registerDB exposes the connection pool statistics of db.
+func (m *metrics) registerDB(db *sql.DB) error {
+	return m.registry.Register(newDBStatsCollector(m.namespace, db))
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -47,41 +215,87 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-var totalRequests = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "http_requests_total",
-		Help: "Number of get requests.",
-	},
-	[]string{"path"},
-)
-
-var responseStatus = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "response_status",
-		Help: "Status of HTTP response",
-	},
-	[]string{"status"},
-)
-
-var httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-	Name: "http_response_time_seconds",
-	Help: "Duration of HTTP requests.",
-}, []string{"path"})
-
-func prometheusMiddleware(next http.Handler) http.Handler {
+// middleware records the RED metrics of every matched route, labelled by its path template.
+func (m *metrics) middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		route := mux.CurrentRoute(r)
-		path, _ := route.GetPathTemplate()
+		path := routeTemplate(r)
+
+		m.httpInFlight.Inc()
+		defer m.httpInFlight.Dec()
 
-		timer := prometheus.NewTimer(httpDuration.WithLabelValues(path))
+		start := time.Now()
 		rw := NewResponseWriter(w)
 		next.ServeHTTP(rw, r)
 
-		statusCode := rw.statusCode
+		m.httpDuration.WithLabelValues(r.Method, path).Observe(time.Since(start).Seconds())
+		m.httpRequests.WithLabelValues(r.Method, path, strconv.Itoa(rw.statusCode)).Inc()
+	})
+}
 
-		responseStatus.WithLabelValues(strconv.Itoa(statusCode)).Inc()
-		totalRequests.WithLabelValues(path).Inc()
+// routeTemplate returns the template of the route matching r, e.g. /users/{id}.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unknown"
+}
 
-		timer.ObserveDuration()
-	})
-}
\ No newline at end of file
+type dbStatsCollector struct {
+	db *sql.DB
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxIdleTimeClosed *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+func newDBStatsCollector(namespace string, db *sql.DB) *dbStatsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db", name), help, nil, nil)
+	}
+
+	return &dbStatsCollector{
+		db:                db,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections to the database."),
+		open:              desc("open_connections", "The number of established connections both in use and idle."),
+		inUse:             desc("in_use_connections", "The number of connections currently in use."),
+		idle:              desc("idle_connections", "The number of idle connections."),
+		waitCount:         desc("wait_count_total", "The total number of connections waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "The total time blocked waiting for a new connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns."),
+		maxIdleTimeClosed: desc("max_idle_time_closed_total", "The total number of connections closed due to SetConnMaxIdleTime."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime."),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxIdleTimeClosed
+	ch <- c.maxLifetimeClosed
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxIdleTimeClosed, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
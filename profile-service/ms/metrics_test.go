@@ -0,0 +1,37 @@
+package ms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricNamespace(t *testing.T) {
+	assert.Equal(t, "profile_service", metricNamespace("profile-service"))
+	assert.Equal(t, "profile_service_v2", metricNamespace("profile.service v2"))
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	app.GET("/users/{id}", func(c IContext) error {
+		return c.Response(200, "ok")
+	})
+
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	app.metrics.mailSent.WithLabelValues("success").Inc()
+
+	rec = httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `profile_service_http_requests_total{method="GET",route="/users/{id}",status="200"} 1`)
+	assert.Contains(t, body, `profile_service_http_request_duration_seconds_count{method="GET",route="/users/{id}"} 1`)
+	assert.Contains(t, body, `profile_service_smtp_mails_total{result="success"} 1`)
+	assert.Contains(t, body, "profile_service_outbox_pending_messages 0")
+}
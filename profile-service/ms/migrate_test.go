@@ -0,0 +1,183 @@
+package ms
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_create_user_invitations.up.sql":   {Data: []byte("CREATE TABLE user_invitations ();")},
+		"0002_create_user_invitations.down.sql": {Data: []byte("DROP TABLE user_invitations;")},
+		"0001_create_profile.up.sql":            {Data: []byte("CREATE TABLE Profile ();")},
+		"0001_create_profile.down.sql":          {Data: []byte("DROP TABLE Profile;")},
+		"README.md":                             {Data: []byte("ignored")},
+	}
+
+	migrations, err := LoadMigrations(fsys)
+
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "create_profile", migrations[0].Name)
+	assert.Equal(t, "DROP TABLE Profile;", migrations[0].Down)
+	assert.Equal(t, int64(2), migrations[1].Version)
+	assert.Equal(t, "CREATE TABLE user_invitations ();", migrations[1].Up)
+}
+
+func TestLoadMigrationsWithoutUpScript(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_profile.down.sql": {Data: []byte("DROP TABLE Profile;")},
+	}
+
+	_, err := LoadMigrations(fsys)
+
+	assert.Error(t, err)
+}
+
+func TestLoadMigrationsDuplicateVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_profile.up.sql": {Data: []byte("CREATE TABLE Profile ();")},
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users ();")},
+	}
+
+	_, err := LoadMigrations(fsys)
+
+	assert.Error(t, err)
+}
+
+func TestMigratorBind(t *testing.T) {
+	pg := &Migrator{driver: "postgres"}
+	mysql := &Migrator{driver: "mysql"}
+	query := "DELETE FROM schema_migrations WHERE version = $1 AND name = $2"
+
+	assert.Equal(t, query, pg.bind(query))
+	assert.Equal(t, "DELETE FROM schema_migrations WHERE version = ? AND name = ?", mysql.bind(query))
+}
+
+func newTestMigrator(t *testing.T, driver string) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	fsys := fstest.MapFS{
+		"0001_create_profile.up.sql":     {Data: []byte("CREATE TABLE Profile ();")},
+		"0001_create_profile.down.sql":   {Data: []byte("DROP TABLE Profile;")},
+		"0002_create_outbox.up.sql":      {Data: []byte("CREATE TABLE outbox ();")},
+		"0002_create_outbox.down.sql":    {Data: []byte("DROP TABLE outbox;")},
+		"0003_add_outbox_index.up.sql":   {Data: []byte("CREATE INDEX outbox_idx ON outbox (id);")},
+		"0003_add_outbox_index.down.sql": {Data: []byte("DROP INDEX outbox_idx;")},
+	}
+	m, err := NewMigrator(db, driver, fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, mock
+}
+
+func expectAppliedVersions(mock sqlmock.Sqlmock, versions ...int64) {
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, v := range versions {
+		rows.AddRow(v, time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).WillReturnRows(rows)
+}
+
+func migrationVersions(migrations []Migration) []int64 {
+	var v []int64
+	for _, m := range migrations {
+		v = append(v, m.Version)
+	}
+	return v
+}
+
+func TestMigratorUpAppliesPending(t *testing.T) {
+	m, mock := newTestMigrator(t, "postgres")
+
+	mock.ExpectExec(`SELECT pg_advisory_lock\(\$1\)`).WithArgs(lockKey()).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectAppliedVersions(mock, 1)
+	for _, v := range []struct {
+		version int64
+		name    string
+		script  string
+	}{{2, "create_outbox", "CREATE TABLE outbox ();"}, {3, "add_outbox_index", "CREATE INDEX outbox_idx ON outbox (id);"}} {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(v.script)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO schema_migrations \(version, name, applied_at\) VALUES \(\$1, \$2, \$3\)`).
+			WithArgs(v.version, v.name, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(lockKey()).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := m.Up(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, migrationVersions(applied))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorUpStopsAtFailedMigration(t *testing.T) {
+	m, mock := newTestMigrator(t, "postgres")
+
+	mock.ExpectExec(`SELECT pg_advisory_lock`).WithArgs(lockKey()).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectAppliedVersions(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE outbox`).WillReturnError(errors.New("permission denied"))
+	mock.ExpectRollback()
+	// the lock is released even though the migration failed
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WithArgs(lockKey()).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := m.Up(context.Background())
+
+	assert.ErrorContains(t, err, "migration 2_create_outbox: permission denied")
+	assert.Empty(t, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorDownSteps(t *testing.T) {
+	m, mock := newTestMigrator(t, "mysql")
+
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\)`).WithArgs(migrationTable, 60).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+	expectAppliedVersions(mock, 1, 2, 3)
+	for _, v := range []struct {
+		version int64
+		script  string
+	}{{3, "DROP INDEX outbox_idx;"}, {2, "DROP TABLE outbox;"}} {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(v.script)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DELETE FROM schema_migrations WHERE version = \?`).WithArgs(v.version).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(`SELECT RELEASE_LOCK\(\?\)`).WithArgs(migrationTable).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reverted, err := m.Down(context.Background(), 2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3, 2}, migrationVersions(reverted))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorLockTimeout(t *testing.T) {
+	m, mock := newTestMigrator(t, "mysql")
+
+	mock.ExpectQuery(`SELECT GET_LOCK`).WithArgs(migrationTable, 60).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(0))
+
+	applied, err := m.Up(context.Background())
+
+	assert.EqualError(t, err, "acquire migration lock: timeout")
+	assert.Empty(t, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
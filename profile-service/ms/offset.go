@@ -0,0 +1,105 @@
+package ms
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+const (
+	consumerPollTimeout = 500 * time.Millisecond
+	redeliveryBackoff   = time.Second
+
+	defaultCommitInterval  = time.Second
+	defaultCommitBatchSize = 100
+)
+
+// offsetCommitter stores the offset of handled messages and commits them in batches when
+// KafkaConfig.ManualCommit is enabled. Otherwise auto is set and librdkafka commits the
+// stored offsets in the background.
+type offsetCommitter struct {
+	c          *kafka.Consumer
+	auto       bool
+	interval   time.Duration
+	batchSize  int
+	pending    int
+	lastCommit time.Time
+	log        func(tag, msg string)
+}
+
+func newOffsetCommitter(c *kafka.Consumer, cfg KafkaConfig, log func(tag, msg string)) *offsetCommitter {
+	interval := cfg.CommitInterval
+	if interval <= 0 {
+		interval = defaultCommitInterval
+	}
+
+	batchSize := cfg.CommitBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCommitBatchSize
+	}
+
+	return &offsetCommitter{
+		c:          c,
+		interval:   interval,
+		batchSize:  batchSize,
+		auto:       !cfg.ManualCommit,
+		lastCommit: time.Now(),
+		log:        log,
+	}
+}
+
+// store marks msg as processed, its offset is part of the next commit.
+func (oc *offsetCommitter) store(msg *kafka.Message) {
+	if _, err := oc.c.StoreMessage(msg); err != nil {
+		oc.log("Consumer", fmt.Sprintf("store offset %s: %s", msg.TopicPartition, err.Error()))
+		return
+	}
+	oc.pending++
+	oc.maybeCommit()
+}
+
+// storeOffsets marks everything before offsets as processed.
+func (oc *offsetCommitter) storeOffsets(offsets []kafka.TopicPartition) {
+	if _, err := oc.c.StoreOffsets(offsets); err != nil {
+		oc.log("Consumer", fmt.Sprintf("store offsets %v: %s", offsets, err.Error()))
+		return
+	}
+	oc.pending += len(offsets)
+	oc.maybeCommit()
+}
+
+func (oc *offsetCommitter) maybeCommit() {
+	if oc.auto || oc.pending == 0 {
+		return
+	}
+	if oc.pending >= oc.batchSize || time.Since(oc.lastCommit) >= oc.interval {
+		oc.commit()
+	}
+}
+
+// commit synchronously commits every stored offset.
+func (oc *offsetCommitter) commit() {
+	oc.lastCommit = time.Now()
+	if oc.pending == 0 {
+		return
+	}
+
+	if _, err := oc.c.Commit(); err != nil {
+		if kafkaErr, ok := err.(kafka.Error); ok && kafkaErr.Code() == kafka.ErrNoOffset {
+			oc.pending = 0
+			return
+		}
+		oc.log("Consumer", fmt.Sprintf("commit offsets: %s", err.Error()))
+		return
+	}
+	oc.pending = 0
+}
+
+// rebalance commits the stored offsets before partitions are handed over to another member.
+func (oc *offsetCommitter) rebalance(c *kafka.Consumer, event kafka.Event) error {
+	if _, ok := event.(kafka.RevokedPartitions); ok {
+		oc.commit()
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package ms
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOffsetCommitterDefaults(t *testing.T) {
+	oc := newOffsetCommitter(nil, KafkaConfig{}, nil)
+	assert.Equal(t, defaultCommitInterval, oc.interval)
+	assert.Equal(t, defaultCommitBatchSize, oc.batchSize)
+
+	oc = newOffsetCommitter(nil, KafkaConfig{CommitInterval: 5 * time.Second, CommitBatchSize: 10}, nil)
+	assert.Equal(t, 5*time.Second, oc.interval)
+	assert.Equal(t, 10, oc.batchSize)
+}
+
+func TestOffsetCommitterNothingPending(t *testing.T) {
+	oc := newOffsetCommitter(nil, KafkaConfig{CommitInterval: time.Nanosecond}, nil)
+	oc.lastCommit = time.Now().Add(-time.Hour)
+
+	// no stored offset, the consumer must not be touched
+	oc.maybeCommit()
+	oc.commit()
+	assert.Equal(t, 0, oc.pending)
+}
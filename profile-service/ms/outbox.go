@@ -0,0 +1,312 @@
+package ms
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sing3demons/profile-service/constants"
+	"github.com/sing3demons/profile-service/logger"
+	"github.com/sing3demons/profile-service/tracing"
+	"github.com/sing3demons/profile-service/utils"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+)
+
+const outboxTable = "outbox"
+
+// outboxSweepInterval is how often the rows older than OutboxConfig.Retention are deleted.
+var outboxSweepInterval = time.Hour
+
+type OutboxConfig struct {
+	// Interval between two polls of the outbox table, default 1s.
+	Interval time.Duration
+	// BatchSize is the maximum number of rows published per poll, default 100.
+	BatchSize int
+	// MaxAttempts parks a row after this many failed publishes, setting its parked_at so the
+	// rows after it are published, default 10. Parked rows are left for an operator to replay within Retention.
+	MaxAttempts int
+	// SendTimeout bounds the wait for the broker acknowledgement of a row, default 30s. A row
+	// not acknowledged in time counts as a failed publish, it may end up published twice.
+	SendTimeout time.Duration
+	// Retention deletes the rows sent or parked longer ago, default 7 days.
+	Retention time.Duration
+}
+
+type outboxMessage struct {
+	id       int64
+	topic    string
+	key      string
+	payload  []byte
+	headers  string
+	attempts int
+}
+
+// outboxHeaders returns the session and trace context of ctx, stored with the row so the
+// relay publishes the message as part of the request that enqueued it.
+func outboxHeaders(ctx context.Context) map[string]string {
+	headers := map[string]string{}
+	tracing.Inject(ctx, propagation.MapCarrier(headers))
+	if session := utils.SessionFromContext(ctx); session != "" {
+		headers[constants.SessionHeader] = session
+	}
+	return headers
+}
+
+// EnqueueOutbox stores a message in the outbox table within tx, so it is only published
+// by the relay once the surrounding business transaction has committed. It logs to the logs of ctx.
+func EnqueueOutbox(ctx context.Context, tx *sql.Tx, topic, key string, message any) error {
+	detailLog, summaryLog := logger.Log(ctx)
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	headers, err := json.Marshal(outboxHeaders(ctx))
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO ` + outboxTable + ` (topic, message_key, payload, headers) VALUES ($1, $2, $3, $4)`
+	cmd := "enqueue_outbox"
+	invoke := GenerateXTid(cmd)
+
+	detailLog.AddOutputRequest(constants.POSTGRES, cmd, invoke, query, map[string]any{"Query": query, "Topic": topic, "Key": key})
+	detailLog.End()
+
+	if _, err := tx.ExecContext(ctx, query, topic, key, string(payload), string(headers)); err != nil {
+		detailLog.AddInputRequest(constants.POSTGRES, cmd, invoke, nil, err)
+		summaryLog.AddErrorBlock(constants.POSTGRES, cmd, "500", err.Error())
+		return err
+	}
+
+	detailLog.AddInputRequest(constants.POSTGRES, cmd, invoke, nil, map[string]any{"message": "success"})
+	summaryLog.AddSuccessBlock(constants.POSTGRES, cmd, "200", "success")
+	return nil
+}
+
+type outboxRelay struct {
+	ms       *application
+	db       *sql.DB
+	producer *Producer
+	cfg      OutboxConfig
+	// send publishes a row and waits for the broker acknowledgement until ctx is done.
+	send   func(ctx context.Context, m outboxMessage) error
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartOutboxRelay publishes pending outbox rows through producer in the background.
+// A row is marked sent only after the broker acknowledged it, so delivery is at-least-once.
+func (app *application) StartOutboxRelay(producer *Producer, cfg OutboxConfig) {
+	if app.conn == nil {
+		app.Log("Outbox", "database is not connected, outbox relay not started")
+		return
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.SendTimeout <= 0 {
+		cfg.SendTimeout = 30 * time.Second
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 7 * 24 * time.Hour
+	}
+
+	relay := &outboxRelay{
+		ms:       app,
+		db:       app.conn,
+		producer: producer,
+		cfg:      cfg,
+		done:     make(chan struct{}),
+	}
+	relay.ctx, relay.cancel = context.WithCancel(context.Background())
+	relay.send = relay.publish
+	app.outbox = relay
+
+	go relay.run()
+	app.Log("Outbox", "outbox relay started")
+}
+
+func (r *outboxRelay) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	sweep := time.NewTicker(outboxSweepInterval)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-sweep.C:
+			if _, err := r.sweep(r.ctx); err != nil && r.ctx.Err() == nil {
+				r.ms.logger.Error("outbox sweep", zap.Error(err))
+			}
+		case <-ticker.C:
+			// drain full batches, a failure waits for the next tick
+			for {
+				n, err := r.publishBatch(r.ctx)
+				if err != nil && r.ctx.Err() == nil {
+					r.ms.logger.Error("outbox relay", zap.Error(err))
+				}
+				if err != nil || n < r.cfg.BatchSize {
+					break
+				}
+			}
+			r.updateBacklog(r.ctx)
+		}
+	}
+}
+
+// Stop cancels the row being published, it is released for the next poll, and waits for the relay.
+func (r *outboxRelay) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+// publishBatch publishes up to BatchSize pending rows in order, claiming them one at a time
+// so no transaction or row lock is held while waiting for the broker. It returns the number
+// of rows settled, published or parked, and stops at the first row to retry with its error.
+func (r *outboxRelay) publishBatch(ctx context.Context) (int, error) {
+	// the outcome is recorded even when ctx is cancelled meanwhile
+	record := context.WithoutCancel(ctx)
+
+	settled := 0
+	for settled < r.cfg.BatchSize {
+		m, ok, err := r.claim(ctx)
+		if err != nil || !ok {
+			return settled, err
+		}
+
+		publishErr := r.send(ctx, m)
+		if ctx.Err() != nil {
+			_, err := r.db.ExecContext(record, `UPDATE `+outboxTable+` SET locked_until = NULL WHERE id = $1`, m.id)
+			return settled, errors.Join(ctx.Err(), err)
+		}
+
+		if publishErr != nil {
+			r.ms.metrics.outboxFailed.Inc()
+			if m.attempts+1 >= r.cfg.MaxAttempts {
+				r.ms.metrics.outboxParked.Inc()
+				r.ms.logger.Error("outbox row parked", zap.Int64("id", m.id), zap.Int("attempts", m.attempts+1), zap.Error(publishErr))
+				if _, err := r.db.ExecContext(record, `UPDATE `+outboxTable+` SET attempts = attempts + 1, last_error = $1, parked_at = $2, locked_until = NULL WHERE id = $3`, publishErr.Error(), time.Now(), m.id); err != nil {
+					return settled, err
+				}
+				settled++
+				continue
+			}
+
+			// keep the order of the remaining rows, they are retried on the next poll
+			if _, err := r.db.ExecContext(record, `UPDATE `+outboxTable+` SET attempts = attempts + 1, last_error = $1, locked_until = NULL WHERE id = $2`, publishErr.Error(), m.id); err != nil {
+				return settled, err
+			}
+			return settled, fmt.Errorf("outbox row %d: %w", m.id, publishErr)
+		}
+
+		r.ms.metrics.outboxPublished.Inc()
+		if _, err := r.db.ExecContext(record, `UPDATE `+outboxTable+` SET sent_at = $1, attempts = attempts + 1, locked_until = NULL WHERE id = $2`, time.Now(), m.id); err != nil {
+			return settled, err
+		}
+		settled++
+	}
+	return settled, nil
+}
+
+// claim leases the oldest pending row for twice SendTimeout, other replicas skip it until
+// then, and a relay that died while publishing it leaves it to the next lease. ok is false
+// when no row is pending.
+func (r *outboxRelay) claim(ctx context.Context) (m outboxMessage, ok bool, err error) {
+	now := time.Now()
+	err = r.db.QueryRowContext(ctx, `UPDATE `+outboxTable+` SET locked_until = $1 WHERE id = (
+		SELECT id FROM `+outboxTable+` WHERE sent_at IS NULL AND parked_at IS NULL AND (locked_until IS NULL OR locked_until < $2)
+		ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, topic, message_key, payload, COALESCE(headers, ''), attempts`, now.Add(2*r.cfg.SendTimeout), now).
+		Scan(&m.id, &m.topic, &m.key, &m.payload, &m.headers, &m.attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m, false, nil
+	}
+	return m, err == nil, err
+}
+
+// sweep deletes the rows sent or parked before the retention and returns how many it deleted.
+func (r *outboxRelay) sweep(ctx context.Context) (int64, error) {
+	before := time.Now().Add(-r.cfg.Retention)
+	result, err := r.db.ExecContext(ctx, `DELETE FROM `+outboxTable+` WHERE sent_at < $1 OR parked_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// publish sends the row and waits for its delivery report up to SendTimeout.
+func (r *outboxRelay) publish(ctx context.Context, m outboxMessage) error {
+	cmd := "outbox_relay"
+	initInvoke := GenerateXTid("outbox")
+
+	req := &http.Request{}
+	req = req.WithContext(m.context(fmt.Sprintf("%s-%d", cmd, m.id)))
+
+	conf := r.ms.logConfig()
+	detailLog, summaryLog := logger.NewLogs(req, initInvoke, cmd, "system", conf)
+
+	timer := time.NewTimer(r.cfg.SendTimeout)
+	defer timer.Stop()
+
+	d := r.producer.SendMessageAsync(m.topic, m.key, json.RawMessage(m.payload), detailLog, summaryLog)
+	var err error
+	select {
+	case <-d.Done():
+		_, err = d.Wait()
+	case <-timer.C:
+		err = fmt.Errorf("no delivery report within %s", r.cfg.SendTimeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	detailLog.AutoEnd()
+	if err != nil {
+		summaryLog.End("500", err.Error())
+		return err
+	}
+
+	summaryLog.End("200", "success")
+	return nil
+}
+
+// context restores the session and trace context stored with the row, rows enqueued
+// before the headers column existed get fallback as session.
+func (m outboxMessage) context(fallback string) context.Context {
+	headers := map[string]string{}
+	if m.headers != "" {
+		_ = json.Unmarshal([]byte(m.headers), &headers)
+	}
+
+	ctx := tracing.Extract(context.Background(), propagation.MapCarrier(headers))
+	session := headers[constants.SessionHeader]
+	if session == "" {
+		session = fallback
+	}
+	return utils.ContextWithSession(ctx, session)
+}
+
+func (r *outboxRelay) updateBacklog(ctx context.Context) {
+	var pending int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+outboxTable+` WHERE sent_at IS NULL AND parked_at IS NULL`).Scan(&pending); err != nil {
+		r.ms.logger.Error("outbox backlog", zap.Error(err))
+		return
+	}
+	r.ms.metrics.outboxPending.Set(float64(pending))
+}
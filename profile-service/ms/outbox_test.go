@@ -0,0 +1,137 @@
+package ms
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestOutboxRelay(t *testing.T, send func(ctx context.Context, m outboxMessage) error) (*outboxRelay, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+	relay := &outboxRelay{ms: app, db: db, cfg: OutboxConfig{BatchSize: 2, MaxAttempts: 3, SendTimeout: time.Second, Retention: time.Hour}, send: send}
+	return relay, mock
+}
+
+func outboxRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "topic", "message_key", "payload", "headers", "attempts"})
+}
+
+func expectClaim(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`UPDATE outbox SET locked_until = \$1 WHERE id = \( SELECT id FROM outbox WHERE sent_at IS NULL AND parked_at IS NULL AND \(locked_until IS NULL OR locked_until < \$2\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(rows)
+}
+
+func TestOutboxRelayStopsAtFailedRow(t *testing.T) {
+	var sent []int64
+	relay, mock := newTestOutboxRelay(t, func(ctx context.Context, m outboxMessage) error {
+		sent = append(sent, m.id)
+		return errors.New("broker down")
+	})
+
+	expectClaim(mock, outboxRows().AddRow(1, "service.verify", "k1", "{}", "", 0))
+	mock.ExpectExec(`UPDATE outbox SET attempts = attempts \+ 1, last_error = \$1, locked_until = NULL WHERE id = \$2`).
+		WithArgs("broker down", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := relay.publishBatch(context.Background())
+
+	// a full batch is not reported so the relay waits for the next tick
+	assert.Equal(t, 0, n)
+	assert.ErrorContains(t, err, "outbox row 1: broker down")
+	assert.Equal(t, []int64{1}, sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOutboxRelayParksAfterMaxAttempts(t *testing.T) {
+	relay, mock := newTestOutboxRelay(t, func(ctx context.Context, m outboxMessage) error {
+		if m.id == 1 {
+			return errors.New("message too large")
+		}
+		return nil
+	})
+
+	expectClaim(mock, outboxRows().AddRow(1, "service.verify", "k1", "{}", "", 2))
+	mock.ExpectExec(`UPDATE outbox SET attempts = attempts \+ 1, last_error = \$1, parked_at = \$2, locked_until = NULL WHERE id = \$3`).
+		WithArgs("message too large", sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectClaim(mock, outboxRows().AddRow(2, "service.verify", "k2", "{}", "", 0))
+	mock.ExpectExec(`UPDATE outbox SET sent_at = \$1`).
+		WithArgs(sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := relay.publishBatch(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1.0, testutil.ToFloat64(relay.ms.metrics.outboxParked))
+	assert.Equal(t, 1.0, testutil.ToFloat64(relay.ms.metrics.outboxPublished))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOutboxRelayEmpty(t *testing.T) {
+	relay, mock := newTestOutboxRelay(t, func(ctx context.Context, m outboxMessage) error {
+		t.Fatal("nothing to send")
+		return nil
+	})
+
+	expectClaim(mock, outboxRows())
+
+	n, err := relay.publishBatch(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOutboxRelayCancelReleasesRow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	relay, mock := newTestOutboxRelay(t, func(ctx context.Context, m outboxMessage) error {
+		// the broker never answers, the relay is stopped meanwhile
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	expectClaim(mock, outboxRows().AddRow(1, "service.verify", "k1", "{}", "", 0))
+	mock.ExpectExec(`UPDATE outbox SET locked_until = NULL WHERE id = \$1`).
+		WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := relay.publishBatch(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 0.0, testutil.ToFloat64(relay.ms.metrics.outboxFailed))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// ago matches a time about d before now.
+type ago time.Duration
+
+func (d ago) Match(v driver.Value) bool {
+	at, ok := v.(time.Time)
+	elapsed := time.Since(at) - time.Duration(d)
+	return ok && elapsed >= 0 && elapsed < time.Second
+}
+
+func TestOutboxRelaySweep(t *testing.T) {
+	relay, mock := newTestOutboxRelay(t, nil)
+
+	mock.ExpectExec(`DELETE FROM outbox WHERE sent_at < \$1 OR parked_at < \$1`).
+		WithArgs(ago(time.Hour)).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := relay.sweep(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
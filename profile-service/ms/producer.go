@@ -2,11 +2,23 @@ package ms
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/sing3demons/profile-service/constants"
 	"github.com/sing3demons/profile-service/logger"
+	"github.com/sing3demons/profile-service/tracing"
+	"github.com/sing3demons/profile-service/utils"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrProducerClosed completes the deliveries still waiting for their report when the producer closes.
+var ErrProducerClosed = errors.New("kafka producer closed")
+
 type IProducer interface {
 	// SendMessage will send message to the partition
 	SendMessage(topic string, key string, message interface{}) error
@@ -17,7 +29,13 @@ type IProducer interface {
 type Producer struct {
 	ms      *application
 	servers string
-	prod    *kafka.Producer
+
+	mu         sync.Mutex
+	prod       *kafka.Producer
+	eventsDone chan struct{}
+
+	pendingMu sync.Mutex
+	pending   map[*Delivery]struct{}
 }
 
 func NewProducer(servers string, ms *application) *Producer {
@@ -27,19 +45,70 @@ func NewProducer(servers string, ms *application) *Producer {
 	}
 }
 
-func (p *Producer) getProducer() *kafka.Producer {
+// getProducer connects on first use and starts the goroutine completing the deliveries.
+func (p *Producer) getProducer() (*kafka.Producer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.prod == nil {
-		prod, _ := p.newKafkaProducer(p.servers)
+		prod, err := p.newKafkaProducer(p.servers)
+		if err != nil {
+			return nil, err
+		}
 		p.prod = prod
+		p.eventsDone = make(chan struct{})
+		go p.handleEvents(prod.Events(), p.eventsDone)
+	}
+	return p.prod, nil
+}
+
+// handleEvents completes the Delivery of every delivery report until the producer is closed.
+func (p *Producer) handleEvents(events <-chan kafka.Event, done chan struct{}) {
+	defer close(done)
+	for e := range events {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			if d, ok := ev.Opaque.(*Delivery); ok {
+				d.complete(ev, ev.TopicPartition.Error)
+			}
+		case kafka.Error:
+			p.ms.Log("PROD", ev.Error())
+		}
 	}
-	return p.prod
 }
 
 // SendMessage send message to topic synchronously
 func (p *Producer) SendMessage(topic string, key string, message interface{}, detailLog logger.DetailLog, summaryLog logger.SummaryLog) error {
+	return p.send(topic, key, message, nil, detailLog, summaryLog)
+}
+
+// SendMessageAsync queues message and returns at once, the delivery report completes the
+// returned Delivery and calls callbacks on the producer event goroutine, so they must not block.
+// The outcome is logged to detailLog and summaryLog on delivery, wait for the deliveries before
+// ending the logs.
+func (p *Producer) SendMessageAsync(topic string, key string, message interface{}, detailLog logger.DetailLog, summaryLog logger.SummaryLog, callbacks ...func(RecordMetadata, error)) *Delivery {
+	return p.sendAsync(topic, key, message, nil, detailLog, summaryLog, callbacks)
+}
+
+func (p *Producer) send(topic string, key string, message interface{}, headers map[string]string, detailLog logger.DetailLog, summaryLog logger.SummaryLog) error {
+	_, err := p.sendAsync(topic, key, message, headers, detailLog, summaryLog, nil).Wait()
+	return err
+}
+
+func (p *Producer) sendAsync(topic string, key string, message interface{}, headers map[string]string, detailLog logger.DetailLog, summaryLog logger.SummaryLog, callbacks []func(RecordMetadata, error)) *Delivery {
+	session := utils.SessionFromContext(contextOf(detailLog))
+	message = withSession(message, session)
+
+	// the consumer continues this trace from the traceparent header
+	ctx, span := tracing.Start(contextOf(detailLog), topic+" publish", trace.SpanKindProducer,
+		semconv.MessagingSystemKafka, semconv.MessagingDestinationName(topic))
+	d := p.newDelivery(topic, span, callbacks)
+	d.detailLog, d.summaryLog = detailLog, summaryLog
+
 	messageJSON, err := json.Marshal(message)
 	if err != nil {
-		return err
+		d.complete(nil, err)
+		return d
 	}
 
 	var keyBytes []byte
@@ -47,45 +116,151 @@ func (p *Producer) SendMessage(topic string, key string, message interface{}, de
 		keyBytes = []byte(key)
 	}
 
-	// Send Message Synchrounously
-	deliveryChan := make(chan kafka.Event)
-
-	prod := p.getProducer()
-
 	msg := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
 		Value:          messageJSON,
 		Key:            keyBytes,
 	}
-	invoke := GenerateXTid("kafka")
-	detailLog.AddOutputRequest("kafka_producer", topic, invoke, nil, message)
-	detailLog.End()
+	for k, v := range headers {
+		msg.Headers = setHeader(msg.Headers, k, v)
+	}
+	tracing.Inject(ctx, kafkaHeaderCarrier{&msg.Headers})
+	if session != "" {
+		msg.Headers = setHeader(msg.Headers, constants.KafkaSessionHeader, session)
+	}
+
+	if detailLog != nil {
+		detailLog.AddOutputRequest("kafka_producer", topic, d.invoke, nil, message)
+	}
 	p.ms.Log("PROD", "Send message to topic: "+topic+" message: "+string(messageJSON))
-	err = prod.Produce(msg, deliveryChan)
+	p.enqueue(msg, d)
+	return d
+}
+
+// enqueue hands msg to librdkafka, d is completed by its delivery report.
+func (p *Producer) enqueue(msg *kafka.Message, d *Delivery) {
+	prod, err := p.getProducer()
 	if err != nil {
-		detailLog.AddInputRequest("kafka_producer", topic, invoke, err, message)
-		summaryLog.End("500", err.Error())
-		return err
+		d.complete(nil, err)
+		return
 	}
 
-	detailLog.AddInputRequest("kafka_producer", topic, invoke, nil, map[string]interface{}{"message": "success"})
-	summaryLog.AddSuccessBlock("kafka_producer", topic, "200", "success")
+	msg.Opaque = d
+	d.start = time.Now()
+	p.track(d)
+	if err := prod.Produce(msg, nil); err != nil {
+		d.complete(nil, err)
+	}
+}
 
-	<-deliveryChan
-	close(deliveryChan)
+// track records d until its delivery report completes it, see Close.
+func (p *Producer) track(d *Delivery) {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	if p.pending == nil {
+		p.pending = map[*Delivery]struct{}{}
+	}
+	p.pending[d] = struct{}{}
+}
 
-	return nil
+func (p *Producer) untrack(d *Delivery) {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	delete(p.pending, d)
 }
 
-// Close the producer
+// failPending completes the deliveries left without a report with ErrProducerClosed, so their
+// Wait returns.
+func (p *Producer) failPending() {
+	p.pendingMu.Lock()
+	pending := p.pending
+	p.pending = nil
+	p.pendingMu.Unlock()
+
+	for d := range pending {
+		d.complete(nil, ErrProducerClosed)
+	}
+}
+
+// withSession stamps session on Payload and Msg messages that do not carry one yet.
+func withSession(message interface{}, session string) interface{} {
+	if session == "" {
+		return message
+	}
+
+	switch m := message.(type) {
+	case Payload:
+		if m.Header.Session == "" {
+			m.Header.Session = session
+		}
+		return m
+	case *Payload:
+		if m != nil && m.Header.Session == "" {
+			m.Header.Session = session
+		}
+	case Msg:
+		if m.Header.Session == "" {
+			m.Header.Session = session
+		}
+		return m
+	case *Msg:
+		if m != nil && m.Header.Session == "" {
+			m.Header.Session = session
+		}
+	}
+	return message
+}
+
+// produce publishes an already encoded message synchronously and reports the broker delivery result.
+func (p *Producer) produce(topic string, key, value []byte, headers []kafka.Header) error {
+	d := p.newDelivery(topic, nil, nil)
+	p.enqueue(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Headers:        headers,
+	}, d)
+	_, err := d.Wait()
+	return err
+}
+
+func (p *Producer) observeDelivery(topic string, start time.Time, err error) {
+	if err != nil {
+		p.ms.metrics.producerFailed.WithLabelValues(topic).Inc()
+		return
+	}
+	p.ms.metrics.producerSent.WithLabelValues(topic).Inc()
+	p.ms.metrics.producerDelivery.WithLabelValues(topic).Observe(time.Since(start).Seconds())
+}
+
+// Flush waits up to timeout for the queued messages to be delivered and returns the number left.
+func (p *Producer) Flush(timeout time.Duration) int {
+	p.mu.Lock()
+	prod := p.prod
+	p.mu.Unlock()
+	if prod == nil {
+		return 0
+	}
+	return prod.Flush(int(timeout.Milliseconds()))
+}
+
+// Close the producer, the messages not delivered meanwhile fail with ErrProducerClosed.
 func (p *Producer) Close() error {
-	if p.prod == nil {
+	p.mu.Lock()
+	prod, eventsDone := p.prod, p.eventsDone
+	p.prod = nil
+	p.mu.Unlock()
+	if prod == nil {
+		p.failPending()
 		return nil
 	}
 
-	prod := p.prod
-	prod.Flush(5000) // 5s for flush message in queue
+	if remaining := prod.Flush(5000); remaining > 0 { // 5s for flush message in queue
+		p.ms.Log("PROD", fmt.Sprintf("%d message(s) not delivered before close", remaining))
+	}
 	prod.Close()
+	<-eventsDone
+	p.failPending()
 
 	p.ms.Log("PROD", "Close successfully")
 
@@ -96,16 +271,12 @@ func (p *Producer) newKafkaProducer(servers string) (*kafka.Producer, error) {
 
 	// Configurations
 	// https://github.com/edenhill/librdkafka/blob/master/CONFIGURATION.md
-	return kafka.NewProducer(&kafka.ConfigMap{
+	config := kafka.ConfigMap{
 
 		// Alias for metadata.broker.list: Initial list of brokers as a CSV list of broker host or host:port.
 		// The application may also use rd_kafka_brokers_add() to add brokers during runtime.
 		"bootstrap.servers": servers,
 
-		// Protocol used to communicate with brokers.
-		// plaintext, ssl, sasl_plaintext, sasl_ssl
-		"security.protocol": "plaintext",
-
 		// Maximum number of messages allowed on the producer queue. This queue is shared by all topics and partitions.
 		// default is 100000 messages
 		// our default = 1000000 messages
@@ -216,5 +387,15 @@ func (p *Producer) newKafkaProducer(servers string) (*kafka.Producer, error) {
 		// retries=INT32_MAX (must be greater than 0),
 		// acks=all, queuing.strategy=fifo. Producer instantation will fail if user-supplied configuration is incompatible.
 		"enable.idempotence": true,
-	})
+	}
+
+	// client id and security settings
+	client, err := p.ms.config.KafkaCfg.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range client {
+		config[key] = value
+	}
+	return kafka.NewProducer(&config)
 }
@@ -0,0 +1,299 @@
+package ms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/sing3demons/profile-service/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Headers attached to messages forwarded to a retry or dead-letter topic.
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderRetryStage        = "x-retry-stage"
+	HeaderRetryNotBefore    = "x-retry-not-before"
+	HeaderAttempts          = "x-attempts"
+	HeaderError             = "x-error"
+	HeaderFailedAt          = "x-failed-at"
+)
+
+// RetryPolicy describes what happens to a message whose handler returned an error.
+type RetryPolicy struct {
+	// MaxRetries is the number of immediate retries before the delayed retry topics are used.
+	MaxRetries int
+	// Backoff is the pause between two immediate retries.
+	Backoff time.Duration
+	// Delays adds one `<topic>.retry.<delay>` topic per entry, tried in order.
+	Delays []time.Duration
+	// DeadLetterTopic receives the messages that exhausted every retry, default `<topic>.dlq`.
+	DeadLetterTopic string
+}
+
+func (p RetryPolicy) deadLetterTopic(topic string) string {
+	if p.DeadLetterTopic != "" {
+		return p.DeadLetterTopic
+	}
+	return topic + ".dlq"
+}
+
+// RetryTopic returns the delayed retry topic name for topic, e.g. `service.register.retry.1m`.
+func RetryTopic(topic string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", topic, formatDelay(delay))
+}
+
+func formatDelay(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+func (ms *application) retryPolicy(topic string) (RetryPolicy, bool) {
+	policy, ok := ms.config.KafkaCfg.Retry[topic]
+	return policy, ok
+}
+
+// handleMessage runs h with the topic retry policy: immediate retries first, then the
+// delayed retry topics and finally the dead-letter topic. It reports whether the message
+// is done with, so its offset may be committed; a message is not done once ctx is.
+func (ms *application) handleMessage(ctx context.Context, message kafkaMessage, h ServiceHandleFunc) bool {
+	topic := message.originalTopic()
+	policy, ok := ms.retryPolicy(topic)
+	if !ok {
+		if err := ms.runHandler(ctx, message, h); err != nil {
+			ms.Log("Consumer", fmt.Sprintf("topic %s: %s", message.topic, err.Error()))
+			// without a policy an auto committed message is dropped, a manual one is redelivered
+			if ms.config.KafkaCfg.ManualCommit || ctx.Err() != nil {
+				return false
+			}
+			ms.logDropped(message, err)
+		}
+		return true
+	}
+
+	// messages of a retry topic are in due order, waiting for the head is enough
+	if notBefore, ok := message.retryNotBefore(); ok {
+		if wait := time.Until(notBefore); wait > 0 && !ms.sleep(ctx, wait) {
+			return false
+		}
+	}
+
+	attempts := message.attempts()
+	var err error
+	for i := 0; i <= policy.MaxRetries; i++ {
+		if i > 0 && policy.Backoff > 0 && !ms.sleep(ctx, policy.Backoff) {
+			return false
+		}
+
+		attempts++
+		if err = ms.runHandler(ctx, message, h); err == nil {
+			return true
+		}
+	}
+	// a handler failing on the cancelled ctx leaves the message to the next owner
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err := ms.forwardFailedMessage(message, policy, attempts, err); err != nil {
+		ms.logger.Error("forward failed message", zap.String("topic", message.topic), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// logDropped records a failed message of a topic without retry policy, skipped in auto
+// commit mode, with where to find it again.
+func (ms *application) logDropped(message kafkaMessage, err error) {
+	ms.metrics.consumerDropped.WithLabelValues(message.topic).Inc()
+	ms.logger.Error("message dropped, the topic has no retry policy",
+		zap.String("topic", message.topic),
+		zap.Int32("partition", message.partition),
+		zap.Int64("offset", message.offset),
+		zap.String("key", message.key),
+		zap.Error(err))
+}
+
+// runHandler calls h once in a consumer span continuing the trace found in the message headers,
+// the context of h is done with ctx.
+func (ms *application) runHandler(ctx context.Context, message kafkaMessage, h ServiceHandleFunc) error {
+	ctx = tracing.Extract(ctx, kafkaHeaderCarrier{&message.headers})
+	ctx, span := tracing.Start(ctx, message.topic+" process", trace.SpanKindConsumer,
+		kafkaAttributes(message.topic, message.partition, message.offset)...)
+
+	c := newConsumerContext(ctx, message, ms)
+	err := c.finish(h(c))
+	tracing.End(span, err)
+	if err != nil {
+		ms.metrics.consumerErrors.WithLabelValues(message.topic).Inc()
+	}
+	return err
+}
+
+// finish sends the deferred messages once the handler returned nil, and ends the logs a
+// failed handler left open with the error result.
+func (h *ConsumerContext) finish(err error) error {
+	if err == nil {
+		_, _, err = h.deferred.settle(http.StatusOK, nil, h.sendKafkaMessage)
+	}
+	if err != nil && (h.l != nil || (h.s != nil && !h.s.IsEnd())) {
+		h.Response(http.StatusInternalServerError, err)
+	}
+	return err
+}
+
+func (ms *application) forwardFailedMessage(message kafkaMessage, policy RetryPolicy, attempts int, cause error) error {
+	topic := message.originalTopic()
+	stage := message.retryStage()
+
+	headers := message.headersWithout(HeaderRetryStage, HeaderRetryNotBefore, HeaderAttempts, HeaderError, HeaderFailedAt)
+	headers = setHeader(headers, HeaderOriginalTopic, topic)
+	headers = setHeader(headers, HeaderAttempts, strconv.Itoa(attempts))
+	headers = setHeader(headers, HeaderError, cause.Error())
+	if _, ok := message.header(HeaderOriginalPartition); !ok {
+		headers = setHeader(headers, HeaderOriginalPartition, strconv.Itoa(int(message.partition)))
+		headers = setHeader(headers, HeaderOriginalOffset, strconv.FormatInt(message.offset, 10))
+	}
+
+	target := policy.deadLetterTopic(topic)
+	if stage < len(policy.Delays) {
+		delay := policy.Delays[stage]
+		target = RetryTopic(topic, delay)
+		headers = setHeader(headers, HeaderRetryStage, strconv.Itoa(stage+1))
+		headers = setHeader(headers, HeaderRetryNotBefore, strconv.FormatInt(time.Now().Add(delay).UnixMilli(), 10))
+	} else {
+		headers = setHeader(headers, HeaderFailedAt, time.Now().Format(time.RFC3339))
+	}
+
+	ms.Log("Consumer", fmt.Sprintf("message from %s failed after %d attempt(s), forwarded to %s: %s", topic, attempts, target, cause.Error()))
+	return ms.sharedProducer().produce(target, []byte(message.key), []byte(message.value), headers)
+}
+
+// ReplayDeadLetters republishes up to limit messages of a dead-letter topic to their original
+// topic, stripping the retry headers. It stops once the topic has been idle for 5 seconds.
+func (ms *application) ReplayDeadLetters(topic string, limit int) (int, error) {
+	c, err := ms.newKafkaConsumer(ms.config.KafkaCfg.Brokers, ms.config.KafkaCfg.GroupID+".replay", kafka.ConfigMap{
+		"enable.auto.commit":       false,
+		"enable.auto.offset.store": false,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	if err := c.Subscribe(topic, nil); err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for limit <= 0 || replayed < limit {
+		msg, err := c.ReadMessage(5 * time.Second)
+		if err != nil {
+			var kafkaErr kafka.Error
+			if errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrTimedOut {
+				break
+			}
+			return replayed, err
+		}
+
+		message := newKafkaMessage(msg)
+		target, ok := message.header(HeaderOriginalTopic)
+		if !ok {
+			return replayed, fmt.Errorf("message %s[%d]@%d has no %s header", topic, message.partition, message.offset, HeaderOriginalTopic)
+		}
+
+		headers := message.headersWithout(HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset,
+			HeaderRetryStage, HeaderRetryNotBefore, HeaderAttempts, HeaderError, HeaderFailedAt)
+		if err := ms.sharedProducer().produce(target, []byte(message.key), []byte(message.value), headers); err != nil {
+			return replayed, err
+		}
+
+		if _, err := c.CommitMessage(msg); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+func (m kafkaMessage) header(key string) (string, bool) {
+	for _, h := range m.headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+func (m kafkaMessage) headersWithout(keys ...string) []kafka.Header {
+	headers := []kafka.Header{}
+	for _, h := range m.headers {
+		skip := false
+		for _, key := range keys {
+			if h.Key == key {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
+
+func (m kafkaMessage) originalTopic() string {
+	if topic, ok := m.header(HeaderOriginalTopic); ok && topic != "" {
+		return topic
+	}
+	return m.topic
+}
+
+func (m kafkaMessage) retryStage() int {
+	v, _ := m.header(HeaderRetryStage)
+	stage, _ := strconv.Atoi(v)
+	return stage
+}
+
+func (m kafkaMessage) attempts() int {
+	v, _ := m.header(HeaderAttempts)
+	attempts, _ := strconv.Atoi(v)
+	return attempts
+}
+
+func (m kafkaMessage) retryNotBefore() (time.Time, bool) {
+	v, ok := m.header(HeaderRetryNotBefore)
+	if !ok {
+		return time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+func setHeader(headers []kafka.Header, key, value string) []kafka.Header {
+	for i := range headers {
+		if headers[i].Key == key {
+			headers[i].Value = []byte(value)
+			return headers
+		}
+	}
+	return append(headers, kafka.Header{Key: key, Value: []byte(value)})
+}
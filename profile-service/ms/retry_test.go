@@ -0,0 +1,95 @@
+package ms
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryTopic(t *testing.T) {
+	tests := []struct {
+		delay    time.Duration
+		expected string
+	}{
+		{30 * time.Second, "service.register.retry.30s"},
+		{time.Minute, "service.register.retry.1m"},
+		{90 * time.Second, "service.register.retry.1m30s"},
+		{time.Hour, "service.register.retry.1h"},
+		{90 * time.Minute, "service.register.retry.1h30m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, RetryTopic("service.register", tt.delay))
+		})
+	}
+}
+
+func TestRetryPolicyDeadLetterTopic(t *testing.T) {
+	assert.Equal(t, "service.register.dlq", RetryPolicy{}.deadLetterTopic("service.register"))
+	assert.Equal(t, "poison", RetryPolicy{DeadLetterTopic: "poison"}.deadLetterTopic("service.register"))
+}
+
+func TestKafkaMessageRetryHeaders(t *testing.T) {
+	notBefore := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	message := kafkaMessage{
+		topic: "service.register.retry.1m",
+		headers: []kafka.Header{
+			{Key: "trace", Value: []byte("abc")},
+			{Key: HeaderOriginalTopic, Value: []byte("service.register")},
+			{Key: HeaderRetryStage, Value: []byte("1")},
+			{Key: HeaderAttempts, Value: []byte("3")},
+			{Key: HeaderRetryNotBefore, Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))},
+		},
+	}
+
+	assert.Equal(t, "service.register", message.originalTopic())
+	assert.Equal(t, 1, message.retryStage())
+	assert.Equal(t, 3, message.attempts())
+
+	due, ok := message.retryNotBefore()
+	assert.True(t, ok)
+	assert.True(t, notBefore.Equal(due))
+
+	headers := message.headersWithout(HeaderOriginalTopic, HeaderRetryStage, HeaderAttempts, HeaderRetryNotBefore)
+	assert.Equal(t, []kafka.Header{{Key: "trace", Value: []byte("abc")}}, headers)
+}
+
+func TestKafkaMessageWithoutRetryHeaders(t *testing.T) {
+	message := kafkaMessage{topic: "service.register"}
+
+	assert.Equal(t, "service.register", message.originalTopic())
+	assert.Equal(t, 0, message.retryStage())
+	assert.Equal(t, 0, message.attempts())
+
+	_, ok := message.retryNotBefore()
+	assert.False(t, ok)
+}
+
+func TestSetHeader(t *testing.T) {
+	headers := setHeader(nil, HeaderAttempts, "1")
+	headers = setHeader(headers, HeaderAttempts, "2")
+
+	assert.Equal(t, []kafka.Header{{Key: HeaderAttempts, Value: []byte("2")}}, headers)
+}
+
+func TestHandleMessageWithoutPolicy(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+	message := kafkaMessage{topic: "service.auth", partition: 2, offset: 41, value: "{}"}
+	failing := func(IContext) error { return errors.New("boom") }
+
+	// auto commit skips the message, counted and logged with its offset
+	assert.True(t, app.handleMessage(context.Background(), message, failing))
+	assert.Equal(t, 1.0, testutil.ToFloat64(app.metrics.consumerDropped.WithLabelValues("service.auth")))
+
+	app.config.KafkaCfg.ManualCommit = true
+	assert.False(t, app.handleMessage(context.Background(), message, failing))
+	assert.Equal(t, 1.0, testutil.ToFloat64(app.metrics.consumerDropped.WithLabelValues("service.auth")))
+}
@@ -0,0 +1,133 @@
+package ms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/sing3demons/profile-service/constants"
+	"github.com/sing3demons/profile-service/logger"
+	"github.com/sing3demons/profile-service/tracing"
+	"github.com/sing3demons/profile-service/utils"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestHTTPSessionPropagation(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	var session string
+	app.GET("/session", func(c IContext) error {
+		session = utils.SessionFromContext(c.Context())
+		return c.Response(200, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/session", nil)
+	req.Header.Set(constants.SessionHeader, "session-1")
+	rec := httptest.NewRecorder()
+	app.router.ServeHTTP(rec, req)
+
+	assert.Equal(t, "session-1", session)
+	assert.Equal(t, "session-1", rec.Header().Get(constants.SessionHeader))
+
+	rec = httptest.NewRecorder()
+	app.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/session", nil))
+
+	assert.NotEmpty(t, session)
+	assert.NotEqual(t, "session-1", session)
+	assert.Equal(t, session, rec.Header().Get(constants.SessionHeader))
+}
+
+func TestConsumerSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		message kafkaMessage
+		want    string
+	}{
+		{
+			name:    "payload header",
+			message: kafkaMessage{topic: "t", value: `{"header":{"session":"from-body"},"body":{}}`, headers: []kafka.Header{{Key: constants.KafkaSessionHeader, Value: []byte("from-header")}}},
+			want:    "from-body",
+		},
+		{
+			name:    "kafka header",
+			message: kafkaMessage{topic: "t", value: `{"body":{}}`, headers: []kafka.Header{{Key: constants.KafkaSessionHeader, Value: []byte("from-header")}}},
+			want:    "from-header",
+		},
+		{
+			name:    "not json",
+			message: kafkaMessage{topic: "t", value: "plain", headers: []kafka.Header{{Key: constants.KafkaSessionHeader, Value: []byte("from-header")}}},
+			want:    "from-header",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConsumerContext(context.Background(), tt.message, nil)
+			assert.Equal(t, tt.want, utils.SessionFromContext(c.Context()))
+			assert.Equal(t, tt.want, c.Payload().Header.Session)
+		})
+	}
+
+	c := newConsumerContext(context.Background(), kafkaMessage{topic: "t", value: "{}"}, nil)
+	session := utils.SessionFromContext(c.Context())
+	assert.True(t, strings.HasPrefix(session, "t-"), session)
+	assert.Equal(t, session, c.ReadInput().Headers.(map[string]interface{})["session"])
+}
+
+func TestWithSession(t *testing.T) {
+	assert.Equal(t, "s", withSession(Payload{}, "s").(Payload).Header.Session)
+	assert.Equal(t, "own", withSession(Msg{Header: Header{Session: "own"}}, "s").(Msg).Header.Session)
+
+	p := &Payload{}
+	withSession(p, "s")
+	assert.Equal(t, "s", p.Header.Session)
+
+	assert.Equal(t, map[string]string{"a": "b"}, withSession(map[string]string{"a": "b"}, "s"))
+}
+
+func TestOutboxMessageContext(t *testing.T) {
+	ctx := utils.ContextWithSession(tracing.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent}), "session-1")
+	headers := outboxHeaders(ctx)
+	assert.Equal(t, "session-1", headers[constants.SessionHeader])
+	assert.Equal(t, traceparent, headers["traceparent"])
+
+	m := outboxMessage{headers: `{"X-Session":"session-1","traceparent":"` + traceparent + `"}`}
+	restored := m.context("fallback")
+	assert.Equal(t, "session-1", utils.SessionFromContext(restored))
+	traceID, _ := tracing.IDs(restored)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+
+	assert.Equal(t, "fallback", utils.SessionFromContext(outboxMessage{}.context("fallback")))
+}
+
+func TestContextCarriesLogs(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	var detailLog, fromContext logger.DetailLog
+	var session string
+	app.GET("/logs", func(c IContext) error {
+		detailLog, _ = c.CommonLog("invoke", "logs", "anonymous")
+		fromContext, _ = logger.Log(c.Context())
+		session = utils.SessionFromContext(c.Context())
+		return c.Response(200, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/logs", nil)
+	req.Header.Set(constants.SessionHeader, "session-1")
+	app.router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Same(t, detailLog, fromContext)
+	assert.Equal(t, "session-1", session)
+
+	c := newConsumerContext(context.Background(), kafkaMessage{topic: "t", value: "{}"}, app)
+	detailLog, summaryLog := c.CommonLog("invoke", "t", "anonymous")
+	fromDetail, fromSummary := logger.Log(c.Context())
+	assert.Same(t, detailLog, fromDetail)
+	assert.Same(t, summaryLog, fromSummary)
+}
@@ -0,0 +1,151 @@
+package ms
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/gorilla/mux"
+	"github.com/sing3demons/profile-service/logger"
+	"github.com/sing3demons/profile-service/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://localhost:4318. Spans are
+	// not exported when both Endpoint and Exporter are empty.
+	Endpoint string
+	// SampleRatio of the root traces kept, default 1 (every trace).
+	SampleRatio float64
+	// Exporter replaces the OTLP exporter, e.g. tracetest.NewInMemoryExporter() in tests.
+	Exporter sdktrace.SpanExporter
+}
+
+// setupTracing installs the W3C propagator and, when an exporter is configured, the tracer provider.
+func (app *application) setupTracing() {
+	otel.SetTextMapPropagator(tracing.Propagator)
+
+	cfg := app.config.Tracing
+	exporter := cfg.Exporter
+	batch := true
+	if exporter == nil {
+		if cfg.Endpoint == "" {
+			return
+		}
+
+		otlp, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		if err != nil {
+			app.logger.Error("tracing exporter", zap.Error(err))
+			return
+		}
+		exporter = otlp
+	} else {
+		// export synchronously so in-memory exporters see the span as soon as it ends
+		batch = false
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(app.config.Name),
+			semconv.DeploymentEnvironment(app.config.Env),
+		)),
+	}
+	if batch {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	} else {
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	}
+
+	app.tracerProvider = sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(app.tracerProvider)
+}
+
+// tracingMiddleware continues the trace of the incoming traceparent header in a server span named after the route.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		ctx := tracing.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, r.Method+" "+route, trace.SpanKindServer,
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.HTTPRoute(route),
+			semconv.URLPath(r.URL.Path),
+		)
+		defer span.End()
+
+		rw := NewResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rw.statusCode))
+		if rw.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.statusCode))
+		}
+	})
+}
+
+// kafkaHeaderCarrier adapts Kafka message headers to the OpenTelemetry propagators.
+type kafkaHeaderCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c kafkaHeaderCarrier) Get(key string) string {
+	for _, h := range *c.headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c kafkaHeaderCarrier) Set(key, value string) {
+	*c.headers = setHeader(*c.headers, key, value)
+}
+
+func (c kafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, h := range *c.headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+func kafkaAttributes(topic string, partition int32, offset int64) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKafka,
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingDestinationPartitionID(strconv.Itoa(int(partition))),
+		semconv.MessagingKafkaMessageOffset(int(offset)),
+	}
+}
+
+// contextOf returns the request context held by detailLog, background when there is none.
+func contextOf(detailLog logger.DetailLog) context.Context {
+	if detailLog == nil {
+		return context.Background()
+	}
+	if ctx := detailLog.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
@@ -0,0 +1,95 @@
+package ms
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/sing3demons/profile-service/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func newTracedApplication(t *testing.T) (*application, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	c := cfg
+	c.Tracing = TracingConfig{Exporter: exporter}
+	app := NewApplication(c).(*application)
+	t.Cleanup(app.CleanUp)
+	return app, exporter
+}
+
+func TestTracingHTTPServerSpan(t *testing.T) {
+	app, exporter := newTracedApplication(t)
+
+	var handlerTraceID string
+	app.GET("/users/{id}", func(c IContext) error {
+		handlerTraceID, _ = tracing.IDs(c.Context())
+		return c.Response(200, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("traceparent", traceparent)
+	app.router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "GET /users/{id}", span.Name)
+		assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+	}
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", handlerTraceID)
+}
+
+func TestTracingConsumerSpan(t *testing.T) {
+	app, exporter := newTracedApplication(t)
+
+	message := kafkaMessage{
+		topic:   "service.register",
+		headers: []kafka.Header{{Key: "traceparent", Value: []byte(traceparent)}},
+	}
+
+	var handlerTraceID string
+	err := app.runHandler(context.Background(), message, func(c IContext) error {
+		handlerTraceID, _ = tracing.IDs(c.Context())
+		return errors.New("boom")
+	})
+	assert.Error(t, err)
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "service.register process", span.Name)
+		assert.Equal(t, trace.SpanKindConsumer, span.SpanKind)
+		assert.Equal(t, codes.Error, span.Status.Code)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+	}
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", handlerTraceID)
+}
+
+func TestKafkaHeaderCarrier(t *testing.T) {
+	_, _ = newTracedApplication(t)
+
+	ctx, span := tracing.Start(context.Background(), "publish", trace.SpanKindProducer)
+	defer span.End()
+
+	headers := []kafka.Header{{Key: "x-attempts", Value: []byte("1")}}
+	tracing.Inject(ctx, kafkaHeaderCarrier{&headers})
+
+	carrier := kafkaHeaderCarrier{&headers}
+	assert.Contains(t, carrier.Keys(), "traceparent")
+	assert.Equal(t, "1", carrier.Get("x-attempts"))
+
+	extracted := trace.SpanContextFromContext(tracing.Extract(context.Background(), carrier))
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), extracted.SpanID())
+}
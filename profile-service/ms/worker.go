@@ -0,0 +1,319 @@
+package ms
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"go.uber.org/zap"
+)
+
+const workerQueueSize = 100
+
+// revokeTimeout bounds how long a revocation waits for the messages in flight, it stays well
+// under max.poll.interval.ms so the consumer keeps its group membership.
+var revokeTimeout = 10 * time.Second
+
+// ConsumeOption tunes a Consume or ConsumeBatch registration.
+type ConsumeOption func(*consumerContext)
+
+// WithWorkers handles the messages of a Consume registration on n goroutines. Messages with
+// the same key, or without key on the same partition, go to the same worker and keep their order.
+func WithWorkers(n int) ConsumeOption {
+	return func(ctx *consumerContext) {
+		ctx.workers = n
+	}
+}
+
+// WithBatchSize caps the number of messages given to a batch handler, default 100.
+func WithBatchSize(n int) ConsumeOption {
+	return func(ctx *consumerContext) {
+		ctx.batchSize = n
+	}
+}
+
+// WithBatchWindow caps how long a batch is filled after its first message, default 1s.
+func WithBatchWindow(d time.Duration) ConsumeOption {
+	return func(ctx *consumerContext) {
+		ctx.batchWindow = d
+	}
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// partitionOffsets follows the messages of one partition handed to the workers.
+type partitionOffsets struct {
+	inFlight map[int64]struct{}
+	next     int64
+	stored   int64
+}
+
+// committable is the offset to commit: the oldest message not done yet, or the one after the
+// last dispatched message when they are all done.
+func (po *partitionOffsets) committable() int64 {
+	offset := po.next
+	for o := range po.inFlight {
+		if o < offset {
+			offset = o
+		}
+	}
+	return offset
+}
+
+// offsetTracker computes the contiguous completed offsets of messages handled out of order.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionOffsets
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: map[partitionKey]*partitionOffsets{}}
+}
+
+func (t *offsetTracker) dispatched(tp kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := partitionKey{*tp.Topic, tp.Partition}
+	po, ok := t.partitions[key]
+	if !ok {
+		po = &partitionOffsets{inFlight: map[int64]struct{}{}, stored: -1}
+		t.partitions[key] = po
+	}
+	offset := int64(tp.Offset)
+	po.inFlight[offset] = struct{}{}
+	if offset+1 > po.next {
+		po.next = offset + 1
+	}
+}
+
+func (t *offsetTracker) done(tp kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if po, ok := t.partitions[partitionKey{*tp.Topic, tp.Partition}]; ok {
+		delete(po.inFlight, int64(tp.Offset))
+	}
+}
+
+// advanced returns the committable offset of every partition that moved since the last call.
+func (t *offsetTracker) advanced() []kafka.TopicPartition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var offsets []kafka.TopicPartition
+	for key, po := range t.partitions {
+		offset := po.committable()
+		if offset <= po.stored {
+			continue
+		}
+		po.stored = offset
+		topic := key.topic
+		offsets = append(offsets, kafka.TopicPartition{Topic: &topic, Partition: key.partition, Offset: kafka.Offset(offset)})
+	}
+	return offsets
+}
+
+// forget drops the revoked partitions, their next owner starts from the committed offset.
+func (t *offsetTracker) forget(partitions []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tp := range partitions {
+		delete(t.partitions, partitionKey{*tp.Topic, tp.Partition})
+	}
+}
+
+// assignment holds the messages dispatched since the last revocation, its context is
+// cancelled by the next one.
+type assignment struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inFlight sync.WaitGroup
+}
+
+func newAssignment() *assignment {
+	a := &assignment{}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	return a
+}
+
+// job is a message queued for a worker with the assignment it was dispatched in.
+type job struct {
+	msg        *kafka.Message
+	assignment *assignment
+}
+
+// workerPool runs the handler of a Consume registration on several goroutines. dispatch and
+// revoke are called from the polling goroutine.
+type workerPool struct {
+	ms      *application
+	h       ServiceHandleFunc
+	queues  []chan job
+	workers sync.WaitGroup
+	current *assignment
+	offsets *offsetTracker
+}
+
+func (ms *application) newWorkerPool(workers int, h ServiceHandleFunc) *workerPool {
+	p := &workerPool{
+		ms:      ms,
+		h:       h,
+		queues:  make([]chan job, workers),
+		current: newAssignment(),
+		offsets: newOffsetTracker(),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan job, workerQueueSize)
+		p.workers.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+// worker picks the queue of msg from its key, or its partition when it has none.
+func (p *workerPool) worker(msg *kafka.Message) int {
+	h := fnv.New32a()
+	if len(msg.Key) > 0 {
+		h.Write(msg.Key)
+	} else {
+		h.Write([]byte(strconv.Itoa(int(msg.TopicPartition.Partition))))
+	}
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// dispatch queues msg, it blocks while the worker queue is full.
+func (p *workerPool) dispatch(msg *kafka.Message) {
+	p.offsets.dispatched(msg.TopicPartition)
+	p.current.inFlight.Add(1)
+	p.queues[p.worker(msg)] <- job{msg: msg, assignment: p.current}
+}
+
+func (p *workerPool) work(queue chan job) {
+	defer p.workers.Done()
+	for j := range queue {
+		// once the consumers stop or the partitions are revoked, the queued messages are left for redelivery
+		if !p.stopped() && j.assignment.ctx.Err() == nil && p.handle(j.assignment.ctx, j.msg) {
+			p.offsets.done(j.msg.TopicPartition)
+		}
+		j.assignment.inFlight.Done()
+	}
+}
+
+// handle runs the handler until the message is done with. A message to redeliver is retried
+// in place, so the worker keeps the key order and its offset is not committed meanwhile.
+// The retries stop once ctx is cancelled by a revocation.
+func (p *workerPool) handle(ctx context.Context, msg *kafka.Message) bool {
+	for {
+		start := time.Now()
+		done := p.ms.handleMessage(ctx, newKafkaMessage(msg), p.h)
+		p.ms.metrics.consumerDuration.WithLabelValues(*msg.TopicPartition.Topic).Observe(time.Since(start).Seconds())
+		if done {
+			return true
+		}
+		if !p.ms.sleep(ctx, redeliveryBackoff) {
+			return false
+		}
+	}
+}
+
+func (p *workerPool) stopped() bool {
+	select {
+	case <-p.ms.stopConsumers:
+		return true
+	default:
+		return false
+	}
+}
+
+// storeOffsets stores the offsets completed since the last call, committed by the committer.
+func (p *workerPool) storeOffsets(committer *offsetCommitter) {
+	if offsets := p.offsets.advanced(); len(offsets) > 0 {
+		committer.storeOffsets(offsets)
+	}
+}
+
+// revoke cancels the messages in flight and waits for them up to revokeTimeout. It reports
+// false when some are still running, their offsets are not committed so the next owner of
+// the partition handles them again.
+func (p *workerPool) revoke() bool {
+	a := p.current
+	p.current = newAssignment()
+	a.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		a.inFlight.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(revokeTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// rebalance cancels the messages in flight before the committer commits on revocation, the
+// eager assignment revokes every partition so none of them is left with a cancelled message.
+func (p *workerPool) rebalance(committer *offsetCommitter) kafka.RebalanceCb {
+	return func(c *kafka.Consumer, event kafka.Event) error {
+		if revoked, ok := event.(kafka.RevokedPartitions); ok {
+			if !p.revoke() {
+				p.ms.logger.Warn("partitions revoked with messages still in flight, they will be redelivered",
+					zap.Duration("timeout", revokeTimeout))
+			}
+			p.storeOffsets(committer)
+			defer p.offsets.forget(revoked.Partitions)
+		}
+		return committer.rebalance(c, event)
+	}
+}
+
+// close stops the workers once they emptied their queue.
+func (p *workerPool) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.workers.Wait()
+}
+
+// consumeConcurrently polls messages for the worker pool until the consumers are stopped.
+func (ms *application) consumeConcurrently(ctx consumerContext, c *kafka.Consumer, committer *offsetCommitter, pool *workerPool) {
+	readTimeout := ctx.readTimeout
+	if readTimeout <= 0 {
+		readTimeout = consumerPollTimeout
+	}
+
+	for {
+		select {
+		case <-ms.stopConsumers:
+			pool.close()
+			pool.storeOffsets(committer)
+			committer.commit()
+			return
+		default:
+		}
+
+		ctx.subscription.refresh(c)
+		msg, err := c.ReadMessage(readTimeout)
+		if err != nil {
+			ms.handleKafkaError(ctx, err)
+		} else {
+			ms.observeConsumed(c, msg)
+			pool.dispatch(msg)
+		}
+
+		pool.storeOffsets(committer)
+		committer.maybeCommit()
+	}
+}
@@ -0,0 +1,243 @@
+package ms
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func testMessage(topic string, partition int32, offset int64, key string, n int) *kafka.Message {
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)},
+		Key:            []byte(key),
+		Value:          []byte(fmt.Sprintf(`{"body":{"n":%d}}`, n)),
+	}
+}
+
+func TestOffsetTrackerContiguous(t *testing.T) {
+	topic := "service.register"
+	tp := func(partition int32, offset int64) kafka.TopicPartition {
+		return kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)}
+	}
+
+	tracker := newOffsetTracker()
+	for offset := int64(10); offset < 14; offset++ {
+		tracker.dispatched(tp(0, offset))
+	}
+	tracker.dispatched(tp(1, 3))
+
+	// 10 is still in flight, nothing after it may be committed
+	tracker.done(tp(0, 11))
+	tracker.done(tp(0, 12))
+	assert.Equal(t, []kafka.TopicPartition{tp(0, 10), tp(1, 3)}, sortOffsets(tracker.advanced()))
+	assert.Empty(t, tracker.advanced())
+
+	tracker.done(tp(0, 10))
+	assert.Equal(t, []kafka.TopicPartition{tp(0, 13)}, tracker.advanced())
+
+	tracker.done(tp(0, 13))
+	tracker.done(tp(1, 3))
+	assert.Equal(t, []kafka.TopicPartition{tp(0, 14), tp(1, 4)}, sortOffsets(tracker.advanced()))
+
+	tracker.forget([]kafka.TopicPartition{tp(0, 0)})
+	tracker.dispatched(tp(0, 20))
+	assert.Equal(t, []kafka.TopicPartition{tp(0, 20)}, tracker.advanced())
+}
+
+func sortOffsets(offsets []kafka.TopicPartition) []kafka.TopicPartition {
+	if len(offsets) == 2 && offsets[0].Partition > offsets[1].Partition {
+		offsets[0], offsets[1] = offsets[1], offsets[0]
+	}
+	return offsets
+}
+
+func TestWorkerPoolKeepsKeyOrder(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	var mu sync.Mutex
+	handled := map[string][]int{}
+	h := func(c IContext) error {
+		var body struct {
+			N int `json:"n"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return err
+		}
+		// later messages of a key finish first unless the worker keeps them in order
+		time.Sleep(time.Duration(10-body.N%10) * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		key := c.(*ConsumerContext).message.key
+		handled[key] = append(handled[key], body.N)
+		return nil
+	}
+
+	pool := app.newWorkerPool(4, h)
+	keys := []string{"user-1", "user-2", "user-3"}
+	for i := 0; i < 30; i++ {
+		pool.dispatch(testMessage("service.register", 0, int64(i), keys[i%len(keys)], i))
+	}
+	pool.current.inFlight.Wait()
+	pool.close()
+
+	for i, key := range keys {
+		var want []int
+		for n := i; n < 30; n += len(keys) {
+			want = append(want, n)
+		}
+		assert.Equal(t, want, handled[key], key)
+	}
+
+	offsets := pool.offsets.advanced()
+	assert.Len(t, offsets, 1)
+	assert.Equal(t, kafka.Offset(30), offsets[0].Offset)
+}
+
+func TestWorkerPoolRevokeStopsRetries(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+	app.config.KafkaCfg.ManualCommit = true
+
+	var calls atomic.Int32
+	pool := app.newWorkerPool(2, func(c IContext) error {
+		if calls.Add(1) == 1 {
+			<-c.Context().Done()
+		}
+		return errors.New("database down")
+	})
+	defer pool.close()
+
+	// the second message of the key waits behind the failing one
+	pool.dispatch(testMessage("service.register", 0, 0, "user-1", 0))
+	pool.dispatch(testMessage("service.register", 0, 1, "user-1", 1))
+	assert.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	start := time.Now()
+	assert.True(t, pool.revoke())
+	assert.Less(t, time.Since(start), redeliveryBackoff)
+	assert.Equal(t, int32(1), calls.Load())
+
+	offsets := pool.offsets.advanced()
+	assert.Len(t, offsets, 1)
+	assert.Equal(t, kafka.Offset(0), offsets[0].Offset)
+}
+
+func TestWorkerPoolRevokeTimeout(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	timeout := revokeTimeout
+	revokeTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { revokeTimeout = timeout })
+
+	started, release := make(chan struct{}), make(chan struct{})
+	pool := app.newWorkerPool(1, func(IContext) error {
+		close(started)
+		<-release
+		return nil
+	})
+	defer pool.close()
+
+	pool.dispatch(testMessage("service.register", 0, 0, "user-1", 0))
+	<-started
+	assert.False(t, pool.revoke())
+	close(release)
+}
+
+func TestWorkerPoolSameWorkerPerKey(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	pool := app.newWorkerPool(8, func(IContext) error { return nil })
+	defer pool.close()
+
+	assert.Equal(t, pool.worker(testMessage("t", 0, 1, "user-1", 0)), pool.worker(testMessage("t", 5, 9, "user-1", 0)))
+	assert.Equal(t, pool.worker(testMessage("t", 3, 1, "", 0)), pool.worker(testMessage("t", 3, 2, "", 0)))
+}
+
+func TestHandleBatch(t *testing.T) {
+	app := NewApplication(cfg).(*application)
+	t.Cleanup(app.CleanUp)
+
+	messages := []kafkaMessage{
+		newKafkaMessage(testMessage("service.auth", 0, 1, "a", 1)),
+		newKafkaMessage(testMessage("service.auth", 0, 2, "b", 2)),
+	}
+
+	var got []int
+	done := app.handleBatch(messages, func(batch []IContext) error {
+		for _, c := range batch {
+			var body struct {
+				N int `json:"n"`
+			}
+			if err := c.Bind(&body); err != nil {
+				return err
+			}
+			got = append(got, body.N)
+		}
+		return nil
+	})
+	assert.Equal(t, 2, done)
+	assert.Equal(t, []int{1, 2}, got)
+
+	// without a retry policy an auto committed batch is dropped
+	failing := func([]IContext) error { return errors.New("boom") }
+	assert.Equal(t, 2, app.handleBatch(messages, failing))
+	assert.Equal(t, 2.0, testutil.ToFloat64(app.metrics.consumerErrors.WithLabelValues("service.auth")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(app.metrics.consumerDropped.WithLabelValues("service.auth")))
+
+	manual := cfg
+	manual.KafkaCfg.ManualCommit = true
+	app = NewApplication(manual).(*application)
+	t.Cleanup(app.CleanUp)
+	assert.Equal(t, 0, app.handleBatch(messages, failing))
+}
+
+func TestBatchOffsets(t *testing.T) {
+	batch := []*kafka.Message{
+		testMessage("service.auth", 0, 10, "a", 1),
+		testMessage("service.auth", 1, 20, "b", 2),
+		testMessage("service.auth", 0, 11, "c", 3),
+		testMessage("service.auth", 1, 21, "d", 4),
+		testMessage("service.auth", 2, 30, "e", 5),
+	}
+	offsets := func(tps []kafka.TopicPartition) map[int32]kafka.Offset {
+		m := map[int32]kafka.Offset{}
+		for _, tp := range tps {
+			m[tp.Partition] = tp.Offset
+		}
+		return m
+	}
+
+	stored, rewind := batchOffsets(batch, len(batch))
+	assert.Equal(t, map[int32]kafka.Offset{0: 12, 1: 22, 2: 31}, offsets(stored))
+	assert.Empty(t, rewind)
+
+	// forwarding failed at the fourth message, the first three are not forwarded again
+	stored, rewind = batchOffsets(batch, 3)
+	assert.Equal(t, map[int32]kafka.Offset{0: 12, 1: 21}, offsets(stored))
+	assert.Equal(t, map[int32]kafka.Offset{1: 21, 2: 30}, offsets(rewind))
+
+	stored, rewind = batchOffsets(batch, 0)
+	assert.Empty(t, stored)
+	assert.Equal(t, map[int32]kafka.Offset{0: 10, 1: 20, 2: 30}, offsets(rewind))
+}
+
+func TestConsumeOptions(t *testing.T) {
+	ctx := consumerContext{}
+	for _, opt := range []ConsumeOption{WithWorkers(4), WithBatchSize(10), WithBatchWindow(time.Minute)} {
+		opt(&ctx)
+	}
+	assert.Equal(t, 4, ctx.workers)
+	assert.Equal(t, 10, ctx.batchSize)
+	assert.Equal(t, time.Minute, ctx.batchWindow)
+}
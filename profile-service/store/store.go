@@ -6,7 +6,9 @@ import (
 	"errors"
 	"time"
 
-	"github.com/sing3demons/profile-service/logger"
+	"github.com/sing3demons/profile-service/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -15,11 +17,17 @@ var (
 	QueryTimeoutDuration = time.Second * 5
 )
 
+// Users methods log to the detail and summary logs carried by the context, see logger.Log.
 type Users interface {
-	GetByID(context.Context, string, logger.DetailLog, logger.SummaryLog) (*User, error)
-	GetByEmail(context.Context, string, logger.DetailLog, logger.SummaryLog) (*User, error)
-	Create(context.Context, *sql.Tx, *User, logger.DetailLog, logger.SummaryLog) error
-	Delete(context.Context, string, logger.DetailLog, logger.SummaryLog) error
+	GetByID(context.Context, string) (*User, error)
+	GetByEmail(context.Context, string) (*User, error)
+	List(context.Context, UserFilter) ([]*User, int, error)
+	Create(context.Context, *sql.Tx, *User) error
+	Update(context.Context, string, UserPatch) (*User, error)
+	Delete(context.Context, string) error
+	CreateAndInvite(context.Context, *User, ...func(*sql.Tx) error) error
+	Invite(context.Context, string, string, time.Duration) (*User, error)
+	Activate(context.Context, string) error
 }
 
 type Storer struct {
@@ -40,6 +48,21 @@ func withTx(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
 	return tx.Commit()
 }
 
+// startQuery opens a client span around one statement, the returned func records its error and ends it.
+func startQuery(ctx context.Context, cmd, query string) (context.Context, func(error)) {
+	ctx, span := tracing.Start(ctx, "postgres "+cmd, trace.SpanKindClient,
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperationName(cmd),
+		semconv.DBQueryText(query),
+	)
+	return ctx, func(err error) {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		tracing.End(span, err)
+	}
+}
+
 func NewStorer(db *sql.DB) *Storer {
 	return &Storer{
 		Users: &UserStore{db: db},
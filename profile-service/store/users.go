@@ -19,6 +19,7 @@ import (
 var (
 	ErrDuplicateEmail    = errors.New("a user with that email already exists")
 	ErrDuplicateUsername = errors.New("a user with that username already exists")
+	ErrAlreadyActive     = errors.New("the user is already active")
 )
 
 type User struct {
@@ -37,6 +38,121 @@ type User struct {
 	UpdatedBy    string   `json:"updated_by,omitempty"`
 	DisplayName  string   `json:"display_name,omitempty"`
 	ProfileImage string   `json:"profile_image,omitempty"`
+	IsActive     bool     `json:"is_active"`
+}
+
+// UserPatch holds the fields of a partial profile update; nil fields are left untouched.
+type UserPatch struct {
+	Email        *string `json:"email,omitempty" validate:"email"`
+	Username     *string `json:"username,omitempty"`
+	FirstName    *string `json:"first_name,omitempty"`
+	LastName     *string `json:"last_name,omitempty"`
+	DateOfBirth  *string `json:"date_of_birth,omitempty"`
+	PhoneNumber  *string `json:"phone_number,omitempty"`
+	Gender       *string `json:"gender,omitempty"`
+	DisplayName  *string `json:"display_name,omitempty"`
+	ProfileImage *string `json:"profile_image,omitempty"`
+	UpdatedBy    *string `json:"updated_by,omitempty"`
+}
+
+type patchField struct {
+	column string
+	value  *string
+}
+
+func (p UserPatch) fields() []patchField {
+	all := []patchField{
+		{"email", p.Email},
+		{"username", p.Username},
+		{"first_name", p.FirstName},
+		{"last_name", p.LastName},
+		{"date_of_birth", p.DateOfBirth},
+		{"phone_number", p.PhoneNumber},
+		{"gender", p.Gender},
+		{"display_name", p.DisplayName},
+		{"profile_image", p.ProfileImage},
+		{"updated_by", p.UpdatedBy},
+	}
+
+	fields := []patchField{}
+	for _, f := range all {
+		if f.value != nil {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// IsEmpty reports whether the patch carries no field to update.
+func (p UserPatch) IsEmpty() bool {
+	return len(p.fields()) == 0
+}
+
+// UserFilter narrows down and paginates the result of UserStore.List.
+type UserFilter struct {
+	Email       string
+	Username    string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Limit       int
+	Offset      int
+}
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+const userColumns = `id, COALESCE(email, ''), COALESCE(username, ''), COALESCE(first_name, ''), COALESCE(last_name, ''),
+	COALESCE(TO_CHAR(date_of_birth, 'YYYY-MM-DD'), ''), COALESCE(phone_number, ''), COALESCE(gender, ''),
+	COALESCE(display_name, ''), COALESCE(profile_image, ''), COALESCE(created_by, ''), COALESCE(updated_by, ''),
+	created_at, updated_at, COALESCE(is_active, FALSE)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner, extra ...any) (*User, error) {
+	user := &User{}
+	dest := []any{
+		&user.ID,
+		&user.Email,
+		&user.Username,
+		&user.FirstName,
+		&user.LastName,
+		&user.DateOfBirth,
+		&user.PhoneNumber,
+		&user.Gender,
+		&user.DisplayName,
+		&user.ProfileImage,
+		&user.CreatedBy,
+		&user.UpdatedBy,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.IsActive,
+	}
+
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// hashToken is how invitation tokens are stored, so a leaked table can't be used to activate accounts.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func duplicateKeyError(err error) error {
+	switch {
+	case strings.Contains(err.Error(), "profile_email_key"):
+		return ErrDuplicateEmail
+	case strings.Contains(err.Error(), "profile_username_key"):
+		return ErrDuplicateUsername
+	default:
+		return err
+	}
 }
 
 type password struct {
@@ -70,8 +186,12 @@ type ProcessLog struct {
 	Return interface{} `json:"Return,omitempty"`
 }
 
-func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User, detailLog logger.DetailLog, summaryLog logger.SummaryLog) error {
-	query := "INSERT INTO Profile (username, password, email) VALUES ($1, $2, $3) RETURNING id, created_at"
+func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
+	detailLog, summaryLog := logger.Log(ctx)
+
+	query := `INSERT INTO Profile (username, password, email, first_name, last_name, date_of_birth, phone_number, gender, display_name, profile_image, created_by, updated_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, '')::date, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NULLIF($11, ''))
+		RETURNING id, created_at`
 
 	cmd := "create_user"
 	invoke := utils.GenerateXTid(cmd)
@@ -86,6 +206,7 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User, detailLo
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
 
 	row := tx.QueryRowContext(
 		ctx,
@@ -93,24 +214,26 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User, detailLo
 		user.Username,
 		user.Password.hash,
 		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.DateOfBirth,
+		user.PhoneNumber,
+		user.Gender,
+		user.DisplayName,
+		user.ProfileImage,
+		user.CreatedBy,
 	)
 
 	err := row.Scan(
 		&user.ID,
 		&user.CreatedAt,
 	)
+	finish(err)
 
 	if err != nil {
 		detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, nil, err)
 		summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
-			return ErrDuplicateUsername
-		default:
-			return err
-		}
+		return duplicateKeyError(err)
 	}
 
 	detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, user, user)
@@ -119,8 +242,10 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User, detailLo
 	return nil
 }
 
-func (s *UserStore) GetByID(ctx context.Context, userID string, detailLog logger.DetailLog, summaryLog logger.SummaryLog) (*User, error) {
-	query := `SELECT id, email, username, created_at, updated_at, first_name, last_name, date_of_birth, phone_number FROM Profile WHERE id = $1`
+func (s *UserStore) GetByID(ctx context.Context, userID string) (*User, error) {
+	detailLog, summaryLog := logger.Log(ctx)
+
+	query := `SELECT ` + userColumns + ` FROM Profile WHERE id = $1`
 
 	cmd := "get_user_by_id"
 	invoke := utils.GenerateXTid(cmd)
@@ -134,23 +259,10 @@ func (s *UserStore) GetByID(ctx context.Context, userID string, detailLog logger
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
 
-	user := &User{}
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		userID,
-	).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Username,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-		&user.FirstName,
-		&user.LastName,
-		&user.DateOfBirth,
-		&user.PhoneNumber,
-	)
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, userID))
+	finish(err)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -165,6 +277,10 @@ func (s *UserStore) GetByID(ctx context.Context, userID string, detailLog logger
 			detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, err.Error(), err)
 			summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", sql.ErrTxDone.Error())
 			return nil, err
+		default:
+			detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, err.Error(), err)
+			summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
+			return nil, err
 		}
 	}
 
@@ -178,13 +294,61 @@ func (s *UserStore) GetByID(ctx context.Context, userID string, detailLog logger
 	return user, nil
 }
 
-func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token string, invitationExp time.Duration, detailLog logger.DetailLog, summaryLog logger.SummaryLog) error {
+// CreateAndInvite creates the user in one transaction with the inTx callbacks, run once the
+// user ID is known (e.g. the outbox write asking for the invitation mail). The invitation
+// itself is issued by Invite when the mail is sent, so its token is only in the mail.
+func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, inTx ...func(*sql.Tx) error) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.Create(ctx, tx, user); err != nil {
+			return err
+		}
+
+		for _, fn := range inTx {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Invite replaces the invitations of an inactive user with token and returns the user to mail
+// it to, the tokens mailed before stop working. ErrAlreadyActive is returned for an active user.
+func (s *UserStore) Invite(ctx context.Context, userID, token string, invitationExp time.Duration) (*User, error) {
+	user, err := s.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsActive {
+		return nil, ErrAlreadyActive
+	}
+
+	err = withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.deleteUserInvitations(ctx, tx, userID); err != nil {
+			return err
+		}
+		return s.createUserInvitation(ctx, tx, token, invitationExp, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Activate marks the user owning a non-expired invitation token as active and consumes the invitation.
+func (s *UserStore) Activate(ctx context.Context, token string) error {
 	return withTx(s.db, ctx, func(tx *sql.Tx) error {
-		if err := s.Create(ctx, tx, user, detailLog, summaryLog); err != nil {
+		user, err := s.getUserFromInvitation(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+
+		if err := s.activate(ctx, tx, user.ID); err != nil {
 			return err
 		}
 
-		if err := s.createUserInvitation(ctx, tx, token, invitationExp, user.ID); err != nil {
+		if err := s.deleteUserInvitations(ctx, tx, user.ID); err != nil {
 			return err
 		}
 
@@ -193,59 +357,102 @@ func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token strin
 }
 
 func (s *UserStore) getUserFromInvitation(ctx context.Context, tx *sql.Tx, token string) (*User, error) {
+	detailLog, summaryLog := logger.Log(ctx)
+
 	query := `
-		SELECT u.id, u.username, u.email, u.created_at
-		FROM users u
+		SELECT u.id, COALESCE(u.username, ''), COALESCE(u.email, ''), u.created_at
+		FROM Profile u
 		JOIN user_invitations ui ON u.id = ui.user_id
 		WHERE ui.token = $1 AND ui.expiry > $2
 	`
 
-	hash := sha256.Sum256([]byte(token))
-	hashToken := hex.EncodeToString(hash[:])
+	cmd := "get_user_from_invitation"
+	invoke := utils.GenerateXTid(cmd)
+
+	processLog := ProcessLog{
+		Query: query,
+	}
+	detailLog.AddOutputRequest(nodeName.POSTGRES, cmd, invoke, fmt.Sprintf("%v", processLog.Query), processLog)
+	detailLog.End()
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
 
 	user := &User{}
-	err := tx.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(
+	err := tx.QueryRowContext(ctx, query, hashToken(token), time.Now()).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.CreatedAt,
 	)
+	finish(err)
 	if err != nil {
+		detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, err.Error(), err)
 		switch err {
 		case sql.ErrNoRows:
+			summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "404", ErrNotFound.Error())
 			return nil, ErrNotFound
 		default:
+			summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
 			return nil, err
 		}
 	}
 
+	processLog = ProcessLog{
+		Return: user,
+	}
+	detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, fmt.Sprintf("%v", processLog.Return), processLog)
+	summaryLog.AddSuccessBlock(nodeName.POSTGRES, cmd, "200", "success")
+
 	return user, nil
 }
 
-func (s *UserStore) createUserInvitation(ctx context.Context, tx *sql.Tx, token string, exp time.Duration, userID string) error {
-	query := `INSERT INTO user_invitations (token, user_id, expiry) VALUES ($1, $2, $3)`
+func (s *UserStore) activate(ctx context.Context, tx *sql.Tx, userID string) error {
+	detailLog, summaryLog := logger.Log(ctx)
+
+	query := `UPDATE Profile SET is_active = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+
+	cmd := "activate_user"
+	invoke := utils.GenerateXTid(cmd)
+
+	processLog := ProcessLog{
+		Query: strings.Replace(query, "$1", fmt.Sprintf("'%s'", userID), 1),
+	}
+	detailLog.AddOutputRequest(nodeName.POSTGRES, cmd, invoke, fmt.Sprintf("%v", processLog.Query), processLog)
+	detailLog.End()
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
 
-	_, err := tx.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	result, err := tx.ExecContext(ctx, query, userID)
+	finish(err)
 	if err != nil {
+		detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, nil, err)
+		summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
 		return err
 	}
 
+	processLog = ProcessLog{
+		Return: result,
+	}
+	detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, fmt.Sprintf("%v", processLog.Return), processLog)
+	summaryLog.AddSuccessBlock(nodeName.POSTGRES, cmd, "200", "success")
+
 	return nil
 }
 
-func (s *UserStore) update(ctx context.Context, tx *sql.Tx, user *User) error {
-	query := `UPDATE users SET username = $1, email = $2 WHERE id = $3`
+func (s *UserStore) createUserInvitation(ctx context.Context, tx *sql.Tx, token string, exp time.Duration, userID string) error {
+	query := `INSERT INTO user_invitations (token, user_id, expiry) VALUES ($1, $2, $3)`
+	cmd := "create_user_invitation"
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
 
-	_, err := tx.ExecContext(ctx, query, user.Username, user.Email, user.ID)
+	_, err := tx.ExecContext(ctx, query, hashToken(token), userID, time.Now().Add(exp))
+	finish(err)
 	if err != nil {
 		return err
 	}
@@ -253,13 +460,86 @@ func (s *UserStore) update(ctx context.Context, tx *sql.Tx, user *User) error {
 	return nil
 }
 
+func (s *UserStore) Update(ctx context.Context, userID string, patch UserPatch) (*User, error) {
+	var user *User
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		updated, err := s.update(ctx, tx, userID, patch)
+		if err != nil {
+			return err
+		}
+		user = updated
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *UserStore) update(ctx context.Context, tx *sql.Tx, userID string, patch UserPatch) (*User, error) {
+	detailLog, summaryLog := logger.Log(ctx)
+
+	sets := []string{}
+	args := []interface{}{}
+
+	for _, f := range patch.fields() {
+		args = append(args, *f.value)
+		if f.column == "date_of_birth" {
+			sets = append(sets, fmt.Sprintf("%s = NULLIF($%d, '')::date", f.column, len(args)))
+			continue
+		}
+		sets = append(sets, fmt.Sprintf("%s = $%d", f.column, len(args)))
+	}
+	sets = append(sets, "updated_at = CURRENT_TIMESTAMP")
+	args = append(args, userID)
+
+	query := fmt.Sprintf(`UPDATE Profile SET %s WHERE id = $%d RETURNING %s`, strings.Join(sets, ", "), len(args), userColumns)
+
+	cmd := "update_user"
+	invoke := utils.GenerateXTid(cmd)
+
+	processLog := ProcessLog{
+		Query:  query,
+		Params: args,
+	}
+	detailLog.AddOutputRequest(nodeName.POSTGRES, cmd, invoke, fmt.Sprintf("%v", processLog.Query), processLog)
+	detailLog.End()
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
+
+	user, err := scanUser(tx.QueryRowContext(ctx, query, args...))
+	finish(err)
+	if err != nil {
+		detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, err.Error(), err)
+		summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, duplicateKeyError(err)
+	}
+
+	processLog = ProcessLog{
+		Return: user,
+	}
+	detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, fmt.Sprintf("%v", processLog.Return), processLog)
+	summaryLog.AddSuccessBlock(nodeName.POSTGRES, cmd, "200", "success")
+
+	return user, nil
+}
+
 func (s *UserStore) deleteUserInvitations(ctx context.Context, tx *sql.Tx, userID string) error {
 	query := `DELETE FROM user_invitations WHERE user_id = $1`
+	cmd := "delete_user_invitations"
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
 
 	_, err := tx.ExecContext(ctx, query, userID)
+	finish(err)
 	if err != nil {
 		return err
 	}
@@ -267,21 +547,11 @@ func (s *UserStore) deleteUserInvitations(ctx context.Context, tx *sql.Tx, userI
 	return nil
 }
 
-func (s *UserStore) Delete(ctx context.Context, userID string, detailLog logger.DetailLog, summaryLog logger.SummaryLog) error {
-	return withTx(s.db, ctx, func(tx *sql.Tx) error {
-		if err := s.delete(ctx, tx, userID, detailLog, summaryLog); err != nil {
-			return err
-		}
-
-		if err := s.deleteUserInvitations(ctx, tx, userID); err != nil {
-			return err
-		}
-
-		return nil
-	})
-}
+// Delete removes the user; its invitations go with it through the ON DELETE CASCADE
+// of user_invitations.user_id.
+func (s *UserStore) Delete(ctx context.Context, id string) error {
+	detailLog, summaryLog := logger.Log(ctx)
 
-func (s *UserStore) delete(ctx context.Context, tx *sql.Tx, id string, detailLog logger.DetailLog, summaryLog logger.SummaryLog) error {
 	query := `DELETE FROM Profile WHERE id = $1`
 	cmd := "delete_user"
 	invoke := utils.GenerateXTid(cmd)
@@ -294,14 +564,22 @@ func (s *UserStore) delete(ctx context.Context, tx *sql.Tx, id string, detailLog
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
 
-	result, err := tx.ExecContext(ctx, query, id)
+	result, err := s.db.ExecContext(ctx, query, id)
+	finish(err)
 	if err != nil {
 		detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, nil, err)
 		summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
 		return err
 	}
 
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, ErrNotFound.Error(), ErrNotFound)
+		summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "404", ErrNotFound.Error())
+		return ErrNotFound
+	}
+
 	processLog = ProcessLog{
 		Return: result,
 	}
@@ -312,8 +590,10 @@ func (s *UserStore) delete(ctx context.Context, tx *sql.Tx, id string, detailLog
 	return nil
 }
 
-func (s *UserStore) GetByEmail(ctx context.Context, email string, detailLog logger.DetailLog, summaryLog logger.SummaryLog) (*User, error) {
-	query := `SELECT id, username, email, password, created_at FROM Profile WHERE email = $1`
+func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	detailLog, summaryLog := logger.Log(ctx)
+
+	query := `SELECT ` + userColumns + ` FROM Profile WHERE email = $1`
 
 	cmd := "select_user_by_email"
 	invoke := utils.GenerateXTid(cmd)
@@ -326,15 +606,10 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string, detailLog logg
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
 
-	user := &User{}
-	err := s.db.QueryRowContext(ctx, query, email).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.Password.hash,
-		&user.CreatedAt,
-	)
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, email))
+	finish(err)
 
 	if err != nil {
 		switch err {
@@ -350,6 +625,10 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string, detailLog logg
 			detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, nil, err)
 			summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", sql.ErrTxDone.Error())
 			return nil, err
+		default:
+			detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, err.Error(), err)
+			summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
+			return nil, err
 		}
 	}
 	processLog = ProcessLog{
@@ -360,3 +639,103 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string, detailLog logg
 
 	return user, nil
 }
+
+func (s *UserStore) List(ctx context.Context, filter UserFilter) ([]*User, int, error) {
+	detailLog, summaryLog := logger.Log(ctx)
+
+	conditions := []string{}
+	args := []interface{}{}
+
+	if filter.Email != "" {
+		args = append(args, filter.Email)
+		conditions = append(conditions, fmt.Sprintf("email = $%d", len(args)))
+	}
+	if filter.Username != "" {
+		args = append(args, filter.Username)
+		conditions = append(conditions, fmt.Sprintf("username = $%d", len(args)))
+	}
+	if filter.CreatedFrom != nil {
+		args = append(args, *filter.CreatedFrom)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if filter.CreatedTo != nil {
+		args = append(args, *filter.CreatedTo)
+		conditions = append(conditions, fmt.Sprintf("created_at <= $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultListLimit
+	}
+	if filter.Limit > MaxListLimit {
+		filter.Limit = MaxListLimit
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	args = append(args, filter.Limit, filter.Offset)
+
+	query := fmt.Sprintf(`SELECT %s, COUNT(*) OVER() FROM Profile %s ORDER BY created_at DESC LIMIT $%d OFFSET $%d`,
+		userColumns, where, len(args)-1, len(args))
+
+	cmd := "list_users"
+	invoke := utils.GenerateXTid(cmd)
+
+	processLog := ProcessLog{
+		Query:  query,
+		Params: args,
+	}
+	detailLog.AddOutputRequest(nodeName.POSTGRES, cmd, invoke, fmt.Sprintf("%v", processLog.Query), processLog)
+	detailLog.End()
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+	ctx, finish := startQuery(ctx, cmd, query)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		finish(err)
+		detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, err.Error(), err)
+		summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	total := 0
+	for rows.Next() {
+		user, err := scanUser(rows, &total)
+		if err != nil {
+			finish(err)
+			detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, err.Error(), err)
+			summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+
+	err = rows.Err()
+	if err == nil && len(users) == 0 && filter.Offset > 0 {
+		// COUNT(*) OVER() comes with the rows, a page past the end counts them apart
+		countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM Profile %s`, where)
+		err = s.db.QueryRowContext(ctx, countQuery, args[:len(args)-2]...).Scan(&total)
+	}
+	finish(err)
+	if err != nil {
+		detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, err.Error(), err)
+		summaryLog.AddErrorBlock(nodeName.POSTGRES, cmd, "500", err.Error())
+		return nil, 0, err
+	}
+
+	processLog = ProcessLog{
+		Return: map[string]interface{}{"total": total, "count": len(users)},
+	}
+	detailLog.AddInputRequest(nodeName.POSTGRES, cmd, invoke, fmt.Sprintf("%v", processLog.Return), processLog)
+	summaryLog.AddSuccessBlock(nodeName.POSTGRES, cmd, "200", "success")
+
+	return users, total, nil
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestUserStore(t *testing.T) (*UserStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &UserStore{db: db}, mock
+}
+
+func userRows(extra ...string) *sqlmock.Rows {
+	columns := []string{"id", "email", "username", "first_name", "last_name", "date_of_birth", "phone_number",
+		"gender", "display_name", "profile_image", "created_by", "updated_by", "created_at", "updated_at", "is_active"}
+	return sqlmock.NewRows(append(columns, extra...))
+}
+
+func TestListOffsetPastEnd(t *testing.T) {
+	s, mock := newTestUserStore(t)
+	mock.ExpectQuery(`SELECT .*, COUNT\(\*\) OVER\(\) FROM Profile WHERE username = \$1`).
+		WithArgs("alice", 20, 40).
+		WillReturnRows(userRows("count"))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM Profile WHERE username = \$1`).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	users, total, err := s.List(context.Background(), UserFilter{Username: "alice", Limit: 20, Offset: 40})
+
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+	assert.Equal(t, 3, total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetByEmailReadsProfile(t *testing.T) {
+	s, mock := newTestUserStore(t)
+	mock.ExpectQuery(`SELECT id, COALESCE\(email, ''\).* FROM Profile WHERE email = \$1`).
+		WithArgs("alice@example.com").
+		WillReturnRows(userRows().AddRow("u1", "alice@example.com", "alice", "Alice", "Liddell", "1990-05-04", "",
+			"", "Alice L.", "", "system", "system", "2024-06-01T09:00:00Z", "2024-06-01T09:00:00Z", true))
+
+	user, err := s.GetByEmail(context.Background(), "alice@example.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", user.FirstName)
+	assert.Equal(t, "Alice L.", user.DisplayName)
+	assert.True(t, user.IsActive)
+}
+
+func TestGetByEmailReturnsQueryErrors(t *testing.T) {
+	s, mock := newTestUserStore(t)
+	mock.ExpectQuery(`FROM Profile WHERE email = \$1`).WillReturnError(context.DeadlineExceeded)
+
+	user, err := s.GetByEmail(context.Background(), "alice@example.com")
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, user)
+}
+
+func TestInviteReplacesInvitations(t *testing.T) {
+	s, mock := newTestUserStore(t)
+	mock.ExpectQuery(`FROM Profile WHERE id = \$1`).WithArgs("u1").
+		WillReturnRows(userRows().AddRow("u1", "alice@example.com", "alice", "", "", "", "",
+			"", "", "", "system", "system", "2024-06-01T09:00:00Z", "2024-06-01T09:00:00Z", false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM user_invitations WHERE user_id = \$1`).WithArgs("u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// only the hash of the token is stored
+	mock.ExpectExec(`INSERT INTO user_invitations`).WithArgs(hashToken("t1"), "u1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := s.Invite(context.Background(), "u1", "t1", time.Hour)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", user.Email)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInviteActiveUser(t *testing.T) {
+	s, mock := newTestUserStore(t)
+	mock.ExpectQuery(`FROM Profile WHERE id = \$1`).WithArgs("u1").
+		WillReturnRows(userRows().AddRow("u1", "alice@example.com", "alice", "", "", "", "",
+			"", "", "", "system", "system", "2024-06-01T09:00:00Z", "2024-06-01T09:00:00Z", true))
+
+	user, err := s.Invite(context.Background(), "u1", "t1", time.Hour)
+
+	assert.ErrorIs(t, err, ErrAlreadyActive)
+	assert.Nil(t, user)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteLeavesInvitationsToCascade(t *testing.T) {
+	s, mock := newTestUserStore(t)
+	// no transaction and no user_invitations statement
+	mock.ExpectExec(`DELETE FROM Profile WHERE id = \$1`).WithArgs("u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, s.Delete(context.Background(), "u1"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/sing3demons/profile-service/constants"
+)
+
+// NewSession returns a new time ordered session ID (UUID v7).
+func NewSession() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.New().String()
+	}
+	return id.String()
+}
+
+// SessionFromContext returns the session stored in ctx, empty when there is none.
+func SessionFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	session, _ := ctx.Value(constants.Session).(string)
+	return session
+}
+
+// ContextWithSession returns a copy of ctx carrying session.
+func ContextWithSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, constants.Session, session)
+}
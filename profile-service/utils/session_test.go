@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNewSession(t *testing.T) {
+	id, err := uuid.Parse(NewSession())
+	if err != nil {
+		t.Fatalf("Expected valid UUID, got error %v", err)
+	}
+	if id.Version() != 7 {
+		t.Errorf("Expected UUID version 7, got %d", id.Version())
+	}
+}
+
+func TestSessionFromContext(t *testing.T) {
+	if session := SessionFromContext(context.Background()); session != "" {
+		t.Errorf("Expected empty session, got %s", session)
+	}
+
+	ctx := ContextWithSession(context.Background(), "session-1")
+	if session := SessionFromContext(ctx); session != "session-1" {
+		t.Errorf("Expected session-1, got %s", session)
+	}
+}